@@ -0,0 +1,776 @@
+// Copyright 2022 CeresDB Project Authors. Licensed under Apache-2.0.
+
+package cluster
+
+import (
+	"context"
+	"hash/crc32"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/ceresdbproto/pkg/clusterpb"
+	"github.com/CeresDB/ceresmeta/server/id"
+	"github.com/CeresDB/ceresmeta/server/schedule"
+	"github.com/CeresDB/ceresmeta/server/storage"
+	"github.com/golang/protobuf/proto" //nolint:staticcheck // clusterpb is generated against the legacy proto-gen-go API
+	"github.com/pkg/errors"
+)
+
+// Schema is a loaded clusterpb.Schema plus the convenience accessors the
+// rest of the package uses.
+type Schema struct {
+	meta *clusterpb.Schema
+}
+
+func (s *Schema) GetID() uint32   { return s.meta.GetId() }
+func (s *Schema) GetName() string { return s.meta.GetName() }
+
+// Table is a loaded clusterpb.Table together with the Schema it belongs to.
+type Table struct {
+	meta   *clusterpb.Table
+	schema *clusterpb.Schema
+}
+
+func (t *Table) GetID() uint64         { return t.meta.GetId() }
+func (t *Table) GetName() string       { return t.meta.GetName() }
+func (t *Table) GetSchemaName() string { return t.schema.GetName() }
+
+// shardTablesWithRole is GetTables's internal view of one shard: its
+// current role, the tables assigned to it, and a version bumped on every
+// change, so callers can tell a cached copy apart from a fresher one.
+type shardTablesWithRole struct {
+	shardRole clusterpb.ShardRole
+	tables    []*Table
+	version   uint64
+}
+
+// ShardAssignmentEvent is broadcast over hbstreams whenever a batch
+// operation changes which tables a shard holds, so that a node can refresh
+// its view of shardIDs without polling.
+type ShardAssignmentEvent struct {
+	ClusterName string
+	ShardIDs    []uint32
+}
+
+// shardInfo is a loaded clusterpb.Shard plus the tables currently assigned
+// to it; table membership is derived (via shardIDFor), not persisted on the
+// shard record itself.
+type shardInfo struct {
+	meta   *clusterpb.Shard
+	tables map[uint64]*Table
+}
+
+// nodeInfo is a registered node and when it was last seen, used to derive
+// NodeLeaseStatuses.
+type nodeInfo struct {
+	meta       *clusterpb.Node
+	lease      uint32
+	lastActive time.Time
+}
+
+// coordinator owns shard-to-node assignment. It is kept as a separate type
+// (rather than methods directly on *Cluster) so that scheduling policy can
+// grow independently of the metadata bookkeeping in the rest of this file.
+type coordinator struct {
+	cluster *Cluster
+}
+
+func newCoordinator(cluster *Cluster) *coordinator {
+	return &coordinator{cluster: cluster}
+}
+
+// scatterShard assigns every currently-unowned shard to one of the
+// cluster's registered nodes, round-robin. It is called after RegisterNode
+// so that a newly (re-)registered node picks up its share of shards without
+// a separate manual rebalance step.
+func (co *coordinator) scatterShard(ctx context.Context) error {
+	c := co.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.nodes) == 0 {
+		return nil
+	}
+
+	nodeNames := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		nodeNames = append(nodeNames, name)
+	}
+	sort.Strings(nodeNames)
+
+	shardIDs := make([]uint32, 0, len(c.shards))
+	for shardID := range c.shards {
+		shardIDs = append(shardIDs, shardID)
+	}
+	sort.Slice(shardIDs, func(i, j int) bool { return shardIDs[i] < shardIDs[j] })
+
+	idx := 0
+	for _, shardID := range shardIDs {
+		shard := c.shards[shardID]
+		if shard.meta.GetNode() != "" {
+			continue
+		}
+
+		shard.meta.Node = nodeNames[idx%len(nodeNames)]
+		shard.meta.ShardRole = clusterpb.ShardRole_LEADER
+		shard.meta.Version++
+		idx++
+
+		if err := c.putShard(ctx, shard.meta); err != nil {
+			return errors.Wrapf(err, "scatterShard, shardID:%d", shardID)
+		}
+	}
+	return nil
+}
+
+// Cluster is a single HoraeDB cluster's metadata: its schemas, tables,
+// shard topology, and registered nodes. It caches everything loaded from
+// storage in memory and writes through on every mutation.
+type Cluster struct {
+	storage   storage.Storage
+	hbstreams *schedule.HeartbeatStreams
+	rootPath  string
+
+	schemaIDAlloc id.Allocator
+	tableIDAlloc  id.Allocator
+
+	coordinator *coordinator
+
+	mu      sync.RWMutex
+	meta    *clusterpb.Cluster
+	schemas map[string]*Schema
+	// tables indexes every table by schema name then table name.
+	tables map[string]map[string]*Table
+	shards map[uint32]*shardInfo
+	nodes  map[string]*nodeInfo
+
+	closed   bool
+	closedCh chan struct{}
+}
+
+// NewCluster returns a Cluster wrapping meta; callers must still call init
+// (for a brand new cluster) or Load (for an existing one) before using it.
+func NewCluster(meta *clusterpb.Cluster, s storage.Storage, hbstreams *schedule.HeartbeatStreams, rootPath string) *Cluster {
+	cluster := &Cluster{
+		storage:       s,
+		hbstreams:     hbstreams,
+		rootPath:      rootPath,
+		schemaIDAlloc: id.NewAllocatorImpl(s, rootPath, meta.GetName(), AllocSchemaIDPrefix),
+		tableIDAlloc:  id.NewAllocatorImpl(s, rootPath, meta.GetName(), AllocTableIDPrefix),
+		meta:          meta,
+		schemas:       make(map[string]*Schema),
+		tables:        make(map[string]map[string]*Table),
+		shards:        make(map[uint32]*shardInfo),
+		nodes:         make(map[string]*nodeInfo),
+		closedCh:      make(chan struct{}),
+	}
+	cluster.coordinator = newCoordinator(cluster)
+	return cluster
+}
+
+func (c *Cluster) Name() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.meta.GetName()
+}
+
+func (c *Cluster) schemaKey(schemaName string) string {
+	return path.Join(c.rootPath, c.meta.GetName(), "schemas", schemaName)
+}
+
+func (c *Cluster) tableKey(tableID uint64) string {
+	return path.Join(c.rootPath, c.meta.GetName(), "tables", strconv.FormatUint(tableID, 10))
+}
+
+func (c *Cluster) shardKey(shardID uint32) string {
+	return path.Join(c.rootPath, c.meta.GetName(), "shards", strconv.FormatUint(uint64(shardID), 10))
+}
+
+func (c *Cluster) nodeKey(nodeName string) string {
+	return path.Join(c.rootPath, c.meta.GetName(), "nodes", nodeName)
+}
+
+// shardIDFor deterministically maps a schema/table name pair to one of the
+// cluster's ShardTotal shards, so shard membership never needs to be
+// persisted as its own record.
+func (c *Cluster) shardIDFor(schemaName, tableName string) uint32 {
+	h := crc32.ChecksumIEEE([]byte(schemaName + "/" + tableName))
+	return h % c.meta.GetShardTotal()
+}
+
+func (c *Cluster) putShard(ctx context.Context, shardPb *clusterpb.Shard) error {
+	value, err := proto.Marshal(shardPb)
+	if err != nil {
+		return errors.Wrapf(err, "marshal shard, shardID:%d", shardPb.GetId())
+	}
+	return c.storage.Put(ctx, c.shardKey(shardPb.GetId()), string(value))
+}
+
+// init persists an empty, unassigned shard record for every shard in
+// [0, ShardTotal), so that Load (and any other metasrv replica) always
+// finds the cluster's full shard set even before any table is created.
+func (c *Cluster) init(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for shardID := uint32(0); shardID < c.meta.GetShardTotal(); shardID++ {
+		shardPb := &clusterpb.Shard{Id: shardID, ClusterId: c.meta.GetId(), ShardRole: clusterpb.ShardRole_FOLLOWER}
+		if err := c.putShard(ctx, shardPb); err != nil {
+			return errors.Wrapf(err, "Cluster init, shardID:%d", shardID)
+		}
+	}
+	return nil
+}
+
+// Load (re)populates the cluster's in-memory schemas/tables/shards/nodes
+// from storage. It is safe to call again later to pick up records written
+// by another metasrv replica.
+func (c *Cluster) Load(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	schemaKVs, err := c.storage.List(ctx, path.Join(c.rootPath, c.meta.GetName(), "schemas"))
+	if err != nil {
+		return errors.Wrap(err, "Cluster Load list schemas")
+	}
+	schemas := make(map[string]*Schema, len(schemaKVs))
+	schemaByID := make(map[uint32]*clusterpb.Schema, len(schemaKVs))
+	for _, value := range schemaKVs {
+		schemaPb := &clusterpb.Schema{}
+		if err := proto.Unmarshal([]byte(value), schemaPb); err != nil {
+			return errors.Wrap(err, "Cluster Load decode schema")
+		}
+		schemas[schemaPb.GetName()] = &Schema{meta: schemaPb}
+		schemaByID[schemaPb.GetId()] = schemaPb
+	}
+
+	tableKVs, err := c.storage.List(ctx, path.Join(c.rootPath, c.meta.GetName(), "tables"))
+	if err != nil {
+		return errors.Wrap(err, "Cluster Load list tables")
+	}
+	tables := make(map[string]map[string]*Table, len(schemas))
+	for _, value := range tableKVs {
+		tablePb := &clusterpb.Table{}
+		if err := proto.Unmarshal([]byte(value), tablePb); err != nil {
+			return errors.Wrap(err, "Cluster Load decode table")
+		}
+		schemaPb := schemaByID[tablePb.GetSchemaId()]
+		if schemaPb == nil {
+			return errors.Errorf("Cluster Load, table refers to unknown schemaId:%d", tablePb.GetSchemaId())
+		}
+		if tables[schemaPb.GetName()] == nil {
+			tables[schemaPb.GetName()] = make(map[string]*Table)
+		}
+		tables[schemaPb.GetName()][tablePb.GetName()] = &Table{meta: tablePb, schema: schemaPb}
+	}
+
+	shardKVs, err := c.storage.List(ctx, path.Join(c.rootPath, c.meta.GetName(), "shards"))
+	if err != nil {
+		return errors.Wrap(err, "Cluster Load list shards")
+	}
+	shards := make(map[uint32]*shardInfo, len(shardKVs))
+	for _, value := range shardKVs {
+		shardPb := &clusterpb.Shard{}
+		if err := proto.Unmarshal([]byte(value), shardPb); err != nil {
+			return errors.Wrap(err, "Cluster Load decode shard")
+		}
+		shards[shardPb.GetId()] = &shardInfo{meta: shardPb, tables: make(map[uint64]*Table)}
+	}
+
+	for schemaName, schemaTables := range tables {
+		for tableName, table := range schemaTables {
+			shardID := c.shardIDFor(schemaName, tableName)
+			if shard, ok := shards[shardID]; ok {
+				shard.tables[table.GetID()] = table
+			}
+		}
+	}
+
+	nodeKVs, err := c.storage.List(ctx, path.Join(c.rootPath, c.meta.GetName(), "nodes"))
+	if err != nil {
+		return errors.Wrap(err, "Cluster Load list nodes")
+	}
+	nodes := make(map[string]*nodeInfo, len(nodeKVs))
+	for _, value := range nodeKVs {
+		nodePb := &clusterpb.Node{}
+		if err := proto.Unmarshal([]byte(value), nodePb); err != nil {
+			return errors.Wrap(err, "Cluster Load decode node")
+		}
+		lastActive := time.Now()
+		if known, ok := c.nodes[nodePb.GetName()]; ok {
+			lastActive = known.lastActive
+		}
+		nodes[nodePb.GetName()] = &nodeInfo{meta: nodePb, lease: nodePb.GetLease(), lastActive: lastActive}
+	}
+
+	c.schemas = schemas
+	c.tables = tables
+	c.shards = shards
+	c.nodes = nodes
+	return nil
+}
+
+// GetOrCreateSchema returns schemaName's Schema, creating and persisting a
+// new one (with a freshly allocated ID) if it doesn't already exist.
+func (c *Cluster) GetOrCreateSchema(ctx context.Context, schemaName string) (*Schema, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getOrCreateSchemaLocked(ctx, schemaName)
+}
+
+func (c *Cluster) getOrCreateSchemaLocked(ctx context.Context, schemaName string) (*Schema, error) {
+	if schema, ok := c.schemas[schemaName]; ok {
+		return schema, nil
+	}
+
+	schemaID, err := c.schemaIDAlloc.Alloc(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "alloc schema id, schemaName:%s", schemaName)
+	}
+
+	schemaPb := &clusterpb.Schema{Id: uint32(schemaID), Name: schemaName, ClusterId: c.meta.GetId()}
+	value, err := proto.Marshal(schemaPb)
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshal schema, schemaName:%s", schemaName)
+	}
+	if err := c.storage.Put(ctx, c.schemaKey(schemaName), string(value)); err != nil {
+		return nil, errors.Wrapf(err, "persist schema, schemaName:%s", schemaName)
+	}
+
+	schema := &Schema{meta: schemaPb}
+	c.schemas[schemaName] = schema
+	return schema, nil
+}
+
+// GetTables returns the tables assigned to shardIDs (every shard if
+// shardIDs is empty), restricted to shards currently owned by nodeName
+// (every node if nodeName is empty).
+func (c *Cluster) GetTables(_ context.Context, shardIDs []uint32, nodeName string) (map[uint32]*shardTablesWithRole, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ids := shardIDs
+	if len(ids) == 0 {
+		ids = make([]uint32, 0, len(c.shards))
+		for shardID := range c.shards {
+			ids = append(ids, shardID)
+		}
+	}
+
+	ret := make(map[uint32]*shardTablesWithRole, len(ids))
+	for _, shardID := range ids {
+		shard, ok := c.shards[shardID]
+		if !ok {
+			continue
+		}
+		if nodeName != "" && shard.meta.GetNode() != nodeName {
+			continue
+		}
+
+		tables := make([]*Table, 0, len(shard.tables))
+		for _, table := range shard.tables {
+			tables = append(tables, table)
+		}
+		ret[shardID] = &shardTablesWithRole{shardRole: shard.meta.GetShardRole(), tables: tables, version: shard.meta.GetVersion()}
+	}
+	return ret, nil
+}
+
+// pendingTable is a spec that survived the schema lookup and the
+// already-exists check in BatchGetOrCreateTables and still needs a table
+// created for it.
+type pendingTable struct {
+	spec     TableSpec
+	schemaPb *clusterpb.Schema
+}
+
+// BatchGetOrCreateTables allocates IDs and persists every spec not already
+// present, index-aligned with specs: tables[i]/errs[i] report spec[i]'s
+// outcome, so one failure doesn't stop the rest of the batch from being
+// attempted. All newly-created tables in the batch share a single
+// tableIDAlloc.AllocN call and a single storage.BatchPut, instead of one
+// allocation and one storage round trip per table, and the batch's
+// resulting shard assignments are flushed to hbstreams once per affected
+// node instead of once per table.
+func (c *Cluster) BatchGetOrCreateTables(ctx context.Context, specs []TableSpec) ([]*Table, []error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tables := make([]*Table, len(specs))
+	errs := make([]error, len(specs))
+
+	pendingIdx := make([]int, 0, len(specs))
+	pending := make([]pendingTable, 0, len(specs))
+	for i, spec := range specs {
+		schema, err := c.getOrCreateSchemaLocked(ctx, spec.SchemaName)
+		if err != nil {
+			errs[i] = errors.Wrapf(err, "BatchGetOrCreateTables, schemaName:%s, tableName:%s", spec.SchemaName, spec.TableName)
+			continue
+		}
+
+		if schemaTables, ok := c.tables[schema.GetName()]; ok {
+			if table, ok := schemaTables[spec.TableName]; ok {
+				tables[i] = table
+				continue
+			}
+		}
+		pendingIdx = append(pendingIdx, i)
+		pending = append(pending, pendingTable{spec: spec, schemaPb: schema.meta})
+	}
+	if len(pending) == 0 {
+		return tables, errs
+	}
+
+	created, touchedShards, err := c.createTablesLocked(ctx, pending)
+	if err != nil {
+		err = errors.Wrap(err, "BatchGetOrCreateTables")
+		for _, i := range pendingIdx {
+			errs[i] = err
+		}
+		return tables, errs
+	}
+	for i, idx := range pendingIdx {
+		tables[idx] = created[i]
+	}
+
+	c.broadcastShardAssignments(touchedShards)
+	return tables, errs
+}
+
+// createTablesLocked allocates a contiguous table-ID range for pending and
+// persists every resulting table plus the shards they land on in a single
+// storage.BatchPut. It is all-or-nothing: on error, c.tables/c.shards are
+// left untouched. c.mu must be held.
+func (c *Cluster) createTablesLocked(ctx context.Context, pending []pendingTable) ([]*Table, map[uint32]*clusterpb.Shard, error) {
+	firstID, err := c.tableIDAlloc.AllocN(ctx, uint64(len(pending)))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "alloc table ids")
+	}
+
+	tables := make([]*Table, len(pending))
+	kvs := make(map[string]string, len(pending))
+	// shardUpdates holds each touched shard's new, not-yet-committed meta,
+	// keyed by shard ID; newShards holds the shardInfo for shards that
+	// don't exist in c.shards yet. Neither is applied to c.shards until the
+	// batched write below succeeds.
+	shardUpdates := make(map[uint32]*clusterpb.Shard)
+	newShards := make(map[uint32]*shardInfo)
+
+	for i, p := range pending {
+		tableID := firstID + uint64(i)
+		tablePb := &clusterpb.Table{Id: tableID, Name: p.spec.TableName, SchemaId: p.schemaPb.GetId()}
+		value, err := proto.Marshal(tablePb)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "marshal table")
+		}
+		kvs[c.tableKey(tableID)] = string(value)
+		tables[i] = &Table{meta: tablePb, schema: p.schemaPb}
+
+		shardID := c.shardIDFor(p.schemaPb.GetName(), p.spec.TableName)
+		shardPb, ok := shardUpdates[shardID]
+		if !ok {
+			if shard, ok := c.shards[shardID]; ok {
+				shardPb = proto.Clone(shard.meta).(*clusterpb.Shard)
+			} else {
+				shardPb = &clusterpb.Shard{Id: shardID, ClusterId: c.meta.GetId()}
+				newShards[shardID] = &shardInfo{meta: shardPb, tables: make(map[uint64]*Table)}
+			}
+			shardUpdates[shardID] = shardPb
+		}
+		if shardPb.GetNode() == "" && p.spec.NodeName != "" {
+			shardPb.Node = p.spec.NodeName
+			shardPb.ShardRole = clusterpb.ShardRole_LEADER
+		}
+	}
+	for shardID, shardPb := range shardUpdates {
+		shardPb.Version++
+		value, err := proto.Marshal(shardPb)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "marshal shard")
+		}
+		kvs[c.shardKey(shardID)] = string(value)
+	}
+
+	if err := c.storage.BatchPut(ctx, kvs); err != nil {
+		return nil, nil, errors.Wrap(err, "persist tables and shards")
+	}
+
+	for shardID, shard := range newShards {
+		c.shards[shardID] = shard
+	}
+	for i, p := range pending {
+		table := tables[i]
+		shardID := c.shardIDFor(p.schemaPb.GetName(), p.spec.TableName)
+		shard := c.shards[shardID]
+		shard.meta = shardUpdates[shardID]
+		shard.tables[table.GetID()] = table
+
+		if c.tables[p.schemaPb.GetName()] == nil {
+			c.tables[p.schemaPb.GetName()] = make(map[string]*Table)
+		}
+		c.tables[p.schemaPb.GetName()][p.spec.TableName] = table
+	}
+
+	return tables, shardUpdates, nil
+}
+
+// broadcastShardAssignments sends one ShardAssignmentEvent per affected
+// node, grouping every touched shard owned by that node into a single
+// event rather than one hbstreams call per shard.
+func (c *Cluster) broadcastShardAssignments(touchedShards map[uint32]*clusterpb.Shard) {
+	nodeShards := make(map[string][]uint32, len(touchedShards))
+	for shardID, shardPb := range touchedShards {
+		if shardPb.GetNode() != "" {
+			nodeShards[shardPb.GetNode()] = append(nodeShards[shardPb.GetNode()], shardID)
+		}
+	}
+	for nodeName, shardIDs := range nodeShards {
+		c.hbstreams.Send(nodeName, ShardAssignmentEvent{ClusterName: c.meta.GetName(), ShardIDs: shardIDs})
+	}
+}
+
+// pendingDrop is a ref that survived the existence check in
+// BatchDropTables and still needs to be dropped.
+type pendingDrop struct {
+	ref     TableRef
+	shardID uint32
+}
+
+// BatchDropTables removes every ref not already missing, index-aligned
+// with refs: errs[i] is nil iff refs[i] succeeded. The whole batch is
+// deleted via a single storage.BatchDelete plus a single storage.BatchPut
+// for the shards it left behind, instead of one Delete/Put per ref, and
+// the resulting shard assignments are flushed to hbstreams once per
+// affected node instead of once per ref.
+func (c *Cluster) BatchDropTables(ctx context.Context, refs []TableRef) []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := make([]error, len(refs))
+	pendingIdx := make([]int, 0, len(refs))
+	pending := make([]pendingDrop, 0, len(refs))
+	for i, ref := range refs {
+		schemaTables, ok := c.tables[ref.SchemaName]
+		if !ok {
+			errs[i] = errors.Errorf("schema not found, schemaName:%s", ref.SchemaName)
+			continue
+		}
+		table, ok := schemaTables[ref.TableName]
+		if !ok || table.GetID() != ref.TableID {
+			errs[i] = errors.Errorf("table not found, tableName:%s, tableID:%d", ref.TableName, ref.TableID)
+			continue
+		}
+		pendingIdx = append(pendingIdx, i)
+		pending = append(pending, pendingDrop{ref: ref, shardID: c.shardIDFor(ref.SchemaName, ref.TableName)})
+	}
+	if len(pending) == 0 {
+		return errs
+	}
+
+	touchedShards, err := c.dropTablesLocked(ctx, pending)
+	if err != nil {
+		err = errors.Wrap(err, "BatchDropTables")
+		for _, i := range pendingIdx {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	c.broadcastShardAssignments(touchedShards)
+	return errs
+}
+
+// dropTablesLocked deletes every pending table's record and persists the
+// shards it left behind in a single storage.BatchDelete + storage.BatchPut
+// pair, applying the resulting state to c.tables/c.shards only once both
+// writes succeed. c.mu must be held.
+func (c *Cluster) dropTablesLocked(ctx context.Context, pending []pendingDrop) (map[uint32]*clusterpb.Shard, error) {
+	deleteKeys := make([]string, 0, len(pending))
+	shardUpdates := make(map[uint32]*clusterpb.Shard)
+	for _, p := range pending {
+		deleteKeys = append(deleteKeys, c.tableKey(p.ref.TableID))
+		if _, ok := shardUpdates[p.shardID]; ok {
+			continue
+		}
+		if shard, ok := c.shards[p.shardID]; ok {
+			shardUpdates[p.shardID] = proto.Clone(shard.meta).(*clusterpb.Shard)
+		}
+	}
+	for _, shardPb := range shardUpdates {
+		shardPb.Version++
+	}
+
+	putKVs := make(map[string]string, len(shardUpdates))
+	for shardID, shardPb := range shardUpdates {
+		value, err := proto.Marshal(shardPb)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal shard")
+		}
+		putKVs[c.shardKey(shardID)] = string(value)
+	}
+
+	if err := c.storage.BatchDelete(ctx, deleteKeys); err != nil {
+		return nil, errors.Wrap(err, "delete tables")
+	}
+	if len(putKVs) > 0 {
+		if err := c.storage.BatchPut(ctx, putKVs); err != nil {
+			return nil, errors.Wrap(err, "persist shards")
+		}
+	}
+
+	for _, p := range pending {
+		delete(c.tables[p.ref.SchemaName], p.ref.TableName)
+		if shard, ok := c.shards[p.shardID]; ok {
+			delete(shard.tables, p.ref.TableID)
+			shard.meta = shardUpdates[p.shardID]
+		}
+	}
+	return shardUpdates, nil
+}
+
+// RegisterNode persists nodeName's registration (lease) and makes it
+// eligible for shard assignment on the next scatterShard.
+func (c *Cluster) RegisterNode(ctx context.Context, nodeName string, lease uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	nodePb := &clusterpb.Node{Name: nodeName, Lease: lease}
+	value, err := proto.Marshal(nodePb)
+	if err != nil {
+		return errors.Wrapf(err, "marshal node, nodeName:%s", nodeName)
+	}
+	if err := c.storage.Put(ctx, c.nodeKey(nodeName), string(value)); err != nil {
+		return errors.Wrapf(err, "persist node, nodeName:%s", nodeName)
+	}
+
+	c.nodes[nodeName] = &nodeInfo{meta: nodePb, lease: lease, lastActive: time.Now()}
+	return nil
+}
+
+// GetShardIDs returns the shards currently owned by nodeName, sorted.
+func (c *Cluster) GetShardIDs(nodeName string) ([]uint32, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	shardIDs := make([]uint32, 0)
+	for shardID, shard := range c.shards {
+		if shard.meta.GetNode() == nodeName {
+			shardIDs = append(shardIDs, shardID)
+		}
+	}
+	sort.Slice(shardIDs, func(i, j int) bool { return shardIDs[i] < shardIDs[j] })
+	return shardIDs, nil
+}
+
+// GetUUID returns the cluster's persisted ClusterUUID, or "" if none has
+// been assigned yet.
+func (c *Cluster) GetUUID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.meta.GetUuid()
+}
+
+// SetUUIDIfEmpty assigns newUUID to this cluster iff it doesn't already
+// have one, via a CAS against storage so that concurrent callers (e.g.
+// racing leader elections calling ensureClusterUUID) assign a UUID exactly
+// once: the loser adopts whatever UUID the winner persisted instead of
+// silently keeping its own empty value.
+func (c *Cluster) SetUUIDIfEmpty(ctx context.Context, newUUID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.meta.GetUuid() != "" {
+		return nil
+	}
+
+	won, err := c.storage.CASClusterUUID(ctx, c.meta.GetName(), newUUID)
+	if err != nil {
+		return errors.Wrapf(err, "SetUUIDIfEmpty, clusterName:%s", c.meta.GetName())
+	}
+	if won {
+		c.meta.Uuid = newUUID
+		return nil
+	}
+
+	clusterPb, err := c.storage.GetCluster(ctx, c.meta.GetName())
+	if err != nil {
+		return errors.Wrapf(err, "SetUUIDIfEmpty reload, clusterName:%s", c.meta.GetName())
+	}
+	c.meta.Uuid = clusterPb.GetUuid()
+	return nil
+}
+
+// ExportState gathers every record belonging to this cluster plus the
+// current schema/table ID allocator watermarks, for Snapshot to serialize.
+func (c *Cluster) ExportState(ctx context.Context) (*ClusterState, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	schemas := make([]*clusterpb.Schema, 0, len(c.schemas))
+	for _, schema := range c.schemas {
+		schemas = append(schemas, schema.meta)
+	}
+
+	tables := make([]*clusterpb.Table, 0)
+	for _, schemaTables := range c.tables {
+		for _, table := range schemaTables {
+			tables = append(tables, table.meta)
+		}
+	}
+
+	shards := make([]*clusterpb.Shard, 0, len(c.shards))
+	for _, shard := range c.shards {
+		shards = append(shards, shard.meta)
+	}
+
+	nodes := make([]*clusterpb.Node, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		nodes = append(nodes, node.meta)
+	}
+
+	schemaWatermark, err := c.schemaIDAlloc.Current(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ExportState read schema id watermark")
+	}
+	tableWatermark, err := c.tableIDAlloc.Current(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ExportState read table id watermark")
+	}
+
+	return &ClusterState{
+		Cluster: c.meta, Schemas: schemas, Tables: tables, Shards: shards, Nodes: nodes,
+		SchemaIDAllocWatermark: schemaWatermark, TableIDAllocWatermark: tableWatermark,
+	}, nil
+}
+
+// NodeLeaseStatuses reports every registered node's lease liveness as of
+// the most recent RegisterNode call (for a node not registered through this
+// Cluster, as of the Load that first found it), sorted by node name.
+func (c *Cluster) NodeLeaseStatuses(_ context.Context) ([]NodeLeaseStatus, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	statuses := make([]NodeLeaseStatus, 0, len(c.nodes))
+	for name, node := range c.nodes {
+		alive := time.Since(node.lastActive) < time.Duration(node.lease)*time.Second
+		statuses = append(statuses, NodeLeaseStatus{NodeName: name, Alive: alive})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].NodeName < statuses[j].NodeName })
+	return statuses, nil
+}
+
+// Close releases the cluster's in-process resources. It is idempotent.
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.closedCh)
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 CeresDB Project Authors. Licensed under Apache-2.0.
+
+package cluster
+
+import "fmt"
+
+// coderr is a minimal causal error, close enough to CeresMeta's own error
+// package to support the .WithCausef chaining already used throughout this
+// package: each WithCausef call returns a new error carrying the message of
+// the sentinel it was called on, which matches that sentinel under
+// errors.Is (via the Is method below), plus a cause-specific message.
+type coderr struct {
+	msg   string
+	cause string
+}
+
+func newErr(msg string) *coderr {
+	return &coderr{msg: msg}
+}
+
+func (e *coderr) Error() string {
+	if e.cause == "" {
+		return e.msg
+	}
+	return fmt.Sprintf("%s: %s", e.msg, e.cause)
+}
+
+// WithCausef returns a new error carrying the same sentinel message plus a
+// formatted cause, e.g. ErrClusterNotFound.WithCausef("clusterName:%s", name).
+func (e *coderr) WithCausef(format string, args ...interface{}) error {
+	return &coderr{msg: e.msg, cause: fmt.Sprintf(format, args...)}
+}
+
+// Is reports whether target is the same sentinel, so that
+// errors.Is(err, ErrClusterNotFound) keeps working after WithCausef.
+func (e *coderr) Is(target error) bool {
+	other, ok := target.(*coderr)
+	return ok && other.msg == e.msg
+}
+
+var (
+	ErrClusterNotFound      = newErr("cluster not found")
+	ErrClusterAlreadyExists = newErr("cluster already exists")
+	ErrCreateCluster        = newErr("fail to create cluster")
+	// ErrClusterBusy is returned instead of blocking when a caller asks for
+	// an expensive, non-blocking per-cluster operation (e.g. RegisterNode)
+	// while another one is already in flight for the same cluster.
+	ErrClusterBusy = newErr("cluster is busy, try again later")
+)
@@ -4,21 +4,53 @@ package cluster
 
 import (
 	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/CeresDB/ceresdbproto/pkg/clusterpb"
 	"github.com/CeresDB/ceresmeta/pkg/log"
 	"github.com/CeresDB/ceresmeta/server/id"
 	"github.com/CeresDB/ceresmeta/server/schedule"
 	"github.com/CeresDB/ceresmeta/server/storage"
+	"github.com/golang/protobuf/proto" //nolint:staticcheck // clusterpb is generated against the legacy proto-gen-go API
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
+// snapshotMagic/snapshotFormatVersion identify the binary format written by
+// Snapshot and understood by Restore, so that an incompatible or corrupt
+// file is rejected up front instead of failing halfway through a restore.
+const (
+	snapshotMagic         uint32 = 0x434d534e // "CMSN"
+	snapshotFormatVersion uint32 = 1
+
+	frameTypeCluster         uint8 = 1
+	frameTypeSchema          uint8 = 2
+	frameTypeTable           uint8 = 3
+	frameTypeShard           uint8 = 4
+	frameTypeNode            uint8 = 5
+	frameTypeAllocWatermarks uint8 = 6
+)
+
 const (
 	AllocClusterIDPrefix = "ClusterID"
 	AllocSchemaIDPrefix  = "SchemaID"
 	AllocTableIDPrefix   = "TableID"
+
+	defaultHealthCheckInterval = time.Second * 30
+	// defaultMaxHealthCheckFailures is the number of consecutive failed
+	// probes after which a cluster is evicted from the manager's cache.
+	defaultMaxHealthCheckFailures = 3
+
+	// defaultReconcileInterval paces the background reconciler when
+	// ManagerOptions.ReadPolicy is Cached; StaleBoundedBy uses its own
+	// staleness bound instead.
+	defaultReconcileInterval = time.Second * 10
 )
 
 type TableInfo struct {
@@ -34,54 +66,598 @@ type ShardTables struct {
 	Version   uint64
 }
 
+// TableSpec identifies a table to be created by BatchAllocTableID.
+type TableSpec struct {
+	SchemaName string
+	TableName  string
+	NodeName   string
+}
+
+// TableRef identifies a table to be dropped by BatchDropTable.
+type TableRef struct {
+	SchemaName string
+	TableName  string
+	TableID    uint64
+}
+
+// BatchError reports the per-item outcome of a batch operation: Errs is
+// index-aligned with the request slice, nil meaning that item succeeded.
+type BatchError struct {
+	Errs []error
+}
+
+// newBatchError returns nil if every entry in errs is nil, so callers can
+// treat a fully-successful batch the same as any other nil error.
+func newBatchError(errs []error) *BatchError {
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errs: errs}
+		}
+	}
+	return nil
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errs {
+		if err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return fmt.Sprintf("%d/%d items failed, first error: %s", failed, len(e.Errs), first)
+}
+
+// ClusterEventType distinguishes the two out-of-band changes a
+// ClusterProvider can report through Watch.
+type ClusterEventType uint8
+
+const (
+	ClusterEventAdded ClusterEventType = iota + 1
+	ClusterEventRemoved
+)
+
+// ClusterEvent is an out-of-band cluster creation/deletion, e.g. performed
+// by another metasrv replica or an operator tool.
+type ClusterEvent struct {
+	Type ClusterEventType
+	Name string
+}
+
+// ClusterDescriptor is the lightweight summary List returns for a cluster;
+// Load is required to get a fully usable *Cluster.
+type ClusterDescriptor struct {
+	Name      string
+	ClusterID uint32
+}
+
+// ClusterProvider decouples managerImpl from any one cluster-metadata
+// backend. It is the only way the manager discovers and loads clusters;
+// production wires up the etcd-backed implementation, tests can substitute
+// newInMemoryClusterProvider instead.
+type ClusterProvider interface {
+	List(ctx context.Context) ([]ClusterDescriptor, error)
+	Load(ctx context.Context, name string) (*Cluster, error)
+	// Watch reports clusters created/deleted out-of-band, letting the
+	// manager react without a full re-list.
+	Watch(ctx context.Context) (<-chan ClusterEvent, error)
+}
+
+// etcdClusterProvider is the production ClusterProvider, backed directly
+// by the etcd-backed storage.Storage.
+type etcdClusterProvider struct {
+	storage   storage.Storage
+	hbstreams *schedule.HeartbeatStreams
+	rootPath  string
+}
+
+func newEtcdClusterProvider(s storage.Storage, hbstreams *schedule.HeartbeatStreams, rootPath string) *etcdClusterProvider {
+	return &etcdClusterProvider{storage: s, hbstreams: hbstreams, rootPath: rootPath}
+}
+
+func (p *etcdClusterProvider) List(ctx context.Context) ([]ClusterDescriptor, error) {
+	clusters, err := p.storage.ListClusters(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdClusterProvider List")
+	}
+
+	descriptors := make([]ClusterDescriptor, 0, len(clusters))
+	for _, clusterPb := range clusters {
+		descriptors = append(descriptors, ClusterDescriptor{Name: clusterPb.GetName(), ClusterID: clusterPb.GetId()})
+	}
+	return descriptors, nil
+}
+
+func (p *etcdClusterProvider) Load(ctx context.Context, name string) (*Cluster, error) {
+	clusters, err := p.storage.ListClusters(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdClusterProvider Load")
+	}
+
+	for _, clusterPb := range clusters {
+		if clusterPb.GetName() != name {
+			continue
+		}
+		cluster := NewCluster(clusterPb, p.storage, p.hbstreams, p.rootPath)
+		if err := cluster.Load(ctx); err != nil {
+			return nil, errors.Wrapf(err, "etcdClusterProvider Load, clusterName:%s", name)
+		}
+		return cluster, nil
+	}
+	return nil, ErrClusterNotFound.WithCausef("etcdClusterProvider Load, clusterName:%s", name)
+}
+
+func (p *etcdClusterProvider) Watch(ctx context.Context) (<-chan ClusterEvent, error) {
+	storageEvents, err := p.storage.WatchClusters(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdClusterProvider Watch")
+	}
+
+	events := make(chan ClusterEvent)
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-storageEvents:
+				if !ok {
+					return
+				}
+				eventType := ClusterEventAdded
+				if e.Deleted {
+					eventType = ClusterEventRemoved
+				}
+				select {
+				case events <- ClusterEvent{Type: eventType, Name: e.ClusterName}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// inMemoryClusterProvider is a ClusterProvider backed by a plain map, for
+// tests that want to exercise managerImpl's cluster-discovery path
+// (including out-of-band ClusterEvents) without etcd.
+type inMemoryClusterProvider struct {
+	mu       sync.Mutex
+	clusters map[string]*Cluster
+	events   chan ClusterEvent
+}
+
+func newInMemoryClusterProvider() *inMemoryClusterProvider {
+	return &inMemoryClusterProvider{clusters: make(map[string]*Cluster), events: make(chan ClusterEvent, 16)}
+}
+
+func (p *inMemoryClusterProvider) List(_ context.Context) ([]ClusterDescriptor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	descriptors := make([]ClusterDescriptor, 0, len(p.clusters))
+	for name := range p.clusters {
+		descriptors = append(descriptors, ClusterDescriptor{Name: name})
+	}
+	return descriptors, nil
+}
+
+func (p *inMemoryClusterProvider) Load(_ context.Context, name string) (*Cluster, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cluster, ok := p.clusters[name]
+	if !ok {
+		return nil, ErrClusterNotFound.WithCausef("inMemoryClusterProvider Load, clusterName:%s", name)
+	}
+	return cluster, nil
+}
+
+func (p *inMemoryClusterProvider) Watch(_ context.Context) (<-chan ClusterEvent, error) {
+	return p.events, nil
+}
+
+// AddCluster makes cluster visible to List/Load and emits a
+// ClusterEventAdded, as if another metasrv replica had just created it.
+func (p *inMemoryClusterProvider) AddCluster(cluster *Cluster) {
+	p.mu.Lock()
+	p.clusters[cluster.Name()] = cluster
+	p.mu.Unlock()
+	p.events <- ClusterEvent{Type: ClusterEventAdded, Name: cluster.Name()}
+}
+
+// RemoveCluster hides name from List/Load and emits a ClusterEventRemoved.
+func (p *inMemoryClusterProvider) RemoveCluster(name string) {
+	p.mu.Lock()
+	delete(p.clusters, name)
+	p.mu.Unlock()
+	p.events <- ClusterEvent{Type: ClusterEventRemoved, Name: name}
+}
+
+// readPolicyMode is the discriminant behind ReadPolicy's three states.
+type readPolicyMode uint8
+
+const (
+	readPolicyLinearizable readPolicyMode = iota
+	readPolicyCached
+	readPolicyStaleBounded
+)
+
+// ReadPolicy controls whether GetTables/GetShards read the cluster's
+// in-memory state under its per-cluster lock on every call, or may instead
+// serve from managerImpl's locally cached view of the shard topology.
+type ReadPolicy struct {
+	mode         readPolicyMode
+	maxStaleness time.Duration
+}
+
+var (
+	// Linearizable always reads the cluster's in-memory state under the
+	// per-cluster lock, which every local write updates; it does not
+	// re-read storage, so writes made by another metasrv replica are not
+	// seen. This is the default and matches the manager's historical
+	// behavior.
+	Linearizable = ReadPolicy{mode: readPolicyLinearizable}
+	// Cached always serves from the local cache once populated, refreshed
+	// only by local writes and the background reconciler.
+	Cached = ReadPolicy{mode: readPolicyCached}
+)
+
+// StaleBoundedBy serves from the local cache as long as it was refreshed
+// within d, falling back to a linearizable read once it goes stale.
+func StaleBoundedBy(d time.Duration) ReadPolicy {
+	return ReadPolicy{mode: readPolicyStaleBounded, maxStaleness: d}
+}
+
+// staleBound returns the cache staleness cachedGetTables/cachedGetShards
+// should tolerate: 0 means "no bound", i.e. Cached's forever-fresh-enough
+// behavior.
+func (p ReadPolicy) staleBound() time.Duration {
+	if p.mode == readPolicyStaleBounded {
+		return p.maxStaleness
+	}
+	return 0
+}
+
+// ManagerOptions configures optional managerImpl behavior; the zero value
+// is equivalent to DefaultManagerOptions (Linearizable reads).
+type ManagerOptions struct {
+	ReadPolicy ReadPolicy
+}
+
+// DefaultManagerOptions preserves the manager's pre-ManagerOptions
+// behavior: every read is linearizable.
+func DefaultManagerOptions() ManagerOptions {
+	return ManagerOptions{ReadPolicy: Linearizable}
+}
+
 type Manager interface {
 	CreateCluster(ctx context.Context, clusterName string, nodeCount, replicationFactor, shardTotal uint32) (*Cluster, error)
 	AllocSchemaID(ctx context.Context, clusterName, schemaName string) (uint32, error)
 	AllocTableID(ctx context.Context, clusterName, schemaName, tableName, nodeName string) (*Table, error)
+	// BatchAllocTableID is the batched counterpart of AllocTableID: it
+	// reserves IDs and persists all specs in one round trip instead of one
+	// per table. See BatchError for how partial failures are reported.
+	BatchAllocTableID(ctx context.Context, clusterName string, specs []TableSpec) ([]*Table, error)
 	GetTables(ctx context.Context, clusterName, nodeName string, shardIDs []uint32) (map[uint32]*ShardTables, error)
 	DropTable(ctx context.Context, clusterName, schemaName, tableName string, tableID uint64) error
+	// BatchDropTable is the batched counterpart of DropTable.
+	BatchDropTable(ctx context.Context, clusterName string, refs []TableRef) error
 	RegisterNode(ctx context.Context, clusterName, nodeName string, lease uint32) error
 	GetShards(ctx context.Context, clusterName, nodeName string) ([]uint32, error)
+	ClusterHealth(ctx context.Context, clusterName string) (HealthStatus, error)
+	// GetClusterUUID returns the cluster's stable, globally-unique
+	// identifier, which (unlike ClusterID) survives a cluster being
+	// dropped and re-created under the same name.
+	GetClusterUUID(ctx context.Context, clusterName string) (string, error)
+	// Snapshot serializes the full state of clusterName (metadata, schemas,
+	// tables, shard topology, node registrations, and ID allocator
+	// watermarks) to w in the versioned binary format read by Restore.
+	Snapshot(ctx context.Context, clusterName string, w io.Writer) error
+	// Restore recreates a cluster from a snapshot produced by Snapshot. The
+	// write is transactional: either the whole cluster is persisted with
+	// its restored IDs, or nothing is written at all.
+	Restore(ctx context.Context, r io.Reader) (*Cluster, error)
+	// Close stops the manager's background workers (e.g. the health
+	// checker). It does not touch already-loaded clusters.
+	Close() error
+}
+
+// ClusterState is the full exportable state of a cluster: its metadata,
+// every schema/table/shard/node record, and the current watermark of each
+// ID allocator. It is the unit serialized by Snapshot and consumed by
+// Restore.
+type ClusterState struct {
+	Cluster *clusterpb.Cluster
+	Schemas []*clusterpb.Schema
+	Tables  []*clusterpb.Table
+	Shards  []*clusterpb.Shard
+	Nodes   []*clusterpb.Node
+
+	SchemaIDAllocWatermark uint64
+	TableIDAllocWatermark  uint64
+}
+
+// NodeLeaseStatus reports whether a registered node's lease is still alive
+// as observed by the most recent health check, i.e. whether the node was
+// last seen less than its lease (in seconds) ago.
+type NodeLeaseStatus struct {
+	NodeName string
+	Alive    bool
+}
+
+// HealthStatus is the result of the most recent health check run against a
+// cluster: storage reachability, heartbeat freshness of its registered
+// nodes, and etcd session liveness.
+type HealthStatus struct {
+	LastCheckTime time.Time
+	FailureCount  int
+	NodeLeases    []NodeLeaseStatus
+}
+
+// clusterLock is a non-blocking mutex guarding a single cluster's expensive
+// operations (e.g. scatterShard, Load). It is implemented as a buffered
+// channel so that, unlike sync.Mutex, callers can attempt to acquire it
+// without blocking and fall back to ErrClusterBusy instead of queueing
+// behind an in-flight call for the same cluster.
+type clusterLock chan struct{}
+
+func newClusterLock() clusterLock {
+	return make(clusterLock, 1)
+}
+
+func (l clusterLock) lock() {
+	l <- struct{}{}
+}
+
+// tryLock attempts to acquire the lock without blocking, reporting whether
+// it succeeded.
+func (l clusterLock) tryLock() bool {
+	select {
+	case l <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l clusterLock) unlock() {
+	<-l
+}
+
+// clusterEntry pairs a loaded cluster with the lock that serializes work
+// against it, so contention on one cluster never affects another.
+type clusterEntry struct {
+	cluster *Cluster
+	lock    clusterLock
+
+	// healthMu guards health below, which is written by the background
+	// health checker and read by ClusterHealth concurrently.
+	healthMu sync.Mutex
+	health   HealthStatus
+
+	// tableCache/shardsCache back GetTables/GetShards when ManagerOptions
+	// opts into a non-linearizable ReadPolicy. They are invalidated by
+	// every local write path and periodically refreshed by the
+	// background reconciler to catch cross-metasrv writes.
+	tableCache  *shardTableCache
+	shardsCache *nodeShardsCache
+}
+
+func newClusterEntry(cluster *Cluster) *clusterEntry {
+	return &clusterEntry{cluster: cluster, lock: newClusterLock(), tableCache: newShardTableCache(), shardsCache: newNodeShardsCache()}
+}
+
+// invalidateCaches drops tableCache/shardsCache; call after any write that
+// changes the cluster's shard topology so cached reads pick up the change
+// on next access instead of waiting for the background reconciler.
+func (e *clusterEntry) invalidateCaches() {
+	e.tableCache.invalidate()
+	e.shardsCache.invalidate()
+}
+
+// shardTableCache is GetTables's cached view of a cluster's shard/table
+// topology, keyed by nodeName like nodeShardsCache so that a lookup scoped
+// to one node can never be served a cached result populated for another
+// node. The empty nodeName is just another key, used by callers asking for
+// every node's tables at once. Each key has its own refresh time.
+type shardTableCache struct {
+	mu        sync.Mutex
+	data      map[string]map[uint32]*ShardTables
+	updatedAt map[string]time.Time
+}
+
+func newShardTableCache() *shardTableCache {
+	return &shardTableCache{data: make(map[string]map[uint32]*ShardTables), updatedAt: make(map[string]time.Time)}
+}
+
+func (c *shardTableCache) get(nodeName string, staleBound time.Duration) (map[uint32]*ShardTables, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if staleBound > 0 && time.Since(c.updatedAt[nodeName]) > staleBound {
+		return nil, false
+	}
+	data, ok := c.data[nodeName]
+	return data, ok
+}
+
+func (c *shardTableCache) set(nodeName string, data map[uint32]*ShardTables) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[nodeName] = data
+	c.updatedAt[nodeName] = time.Now()
+}
+
+func (c *shardTableCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = make(map[string]map[uint32]*ShardTables)
+	c.updatedAt = make(map[string]time.Time)
+}
+
+// nodeShardsCache is GetShards's cached view of each node's assigned
+// shard IDs, keyed by node name; each key has its own refresh time.
+type nodeShardsCache struct {
+	mu        sync.Mutex
+	data      map[string][]uint32
+	updatedAt map[string]time.Time
+}
+
+func newNodeShardsCache() *nodeShardsCache {
+	return &nodeShardsCache{data: make(map[string][]uint32), updatedAt: make(map[string]time.Time)}
+}
+
+func (c *nodeShardsCache) get(nodeName string, staleBound time.Duration) ([]uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if staleBound > 0 && time.Since(c.updatedAt[nodeName]) > staleBound {
+		return nil, false
+	}
+	shardIDs, ok := c.data[nodeName]
+	return shardIDs, ok
+}
+
+func (c *nodeShardsCache) set(nodeName string, shardIDs []uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[nodeName] = shardIDs
+	c.updatedAt[nodeName] = time.Now()
+}
+
+func (c *nodeShardsCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = make(map[string][]uint32)
+	c.updatedAt = make(map[string]time.Time)
 }
 
 type managerImpl struct {
-	// RWMutex is used to protect clusters when creating new cluster
+	// RWMutex only protects the clusters map itself (lookup/insert/delete);
+	// it must never be held while work against a single cluster runs, that
+	// is the job of clusterEntry.lock.
 	lock     sync.RWMutex
-	clusters map[string]*Cluster
+	clusters map[string]*clusterEntry
 
 	storage   storage.Storage
 	alloc     id.Allocator
 	hbstreams *schedule.HeartbeatStreams
 	rootPath  string
+	provider  ClusterProvider
+	opts      ManagerOptions
+
+	healthCheckInterval    time.Duration
+	maxHealthCheckFailures int
+	stopHealthCheck        chan struct{}
+	wg                     sync.WaitGroup
+
+	// watchCtx/watchCancel back runProviderWatchLoop's call to
+	// provider.Watch. They are derived from context.Background(), not the
+	// constructor's ctx, so that watch keeps running for the manager's
+	// whole lifetime even if the caller's ctx is scoped to (and cancelled
+	// after) startup; Close cancels watchCtx to stop the loop.
+	watchCtx    context.Context
+	watchCancel context.CancelFunc
 }
 
 func NewManagerImpl(ctx context.Context, storage storage.Storage, hbstream *schedule.HeartbeatStreams, rootPath string) (Manager, error) {
-	alloc := id.NewAllocatorImpl(storage, rootPath, AllocClusterIDPrefix)
+	return NewManagerImplWithProvider(ctx, storage, hbstream, rootPath, newEtcdClusterProvider(storage, hbstream, rootPath))
+}
+
+// NewManagerImplWithProvider is like NewManagerImpl but takes an explicit
+// ClusterProvider for cluster discovery instead of always talking to
+// storage directly, e.g. newInMemoryClusterProvider() in tests.
+func NewManagerImplWithProvider(ctx context.Context, storage storage.Storage, hbstream *schedule.HeartbeatStreams, rootPath string, provider ClusterProvider) (Manager, error) {
+	return NewManagerImplWithOptions(ctx, storage, hbstream, rootPath, provider, DefaultManagerOptions())
+}
+
+// NewManagerImplWithOptions is the fully-parameterized constructor the
+// other NewManagerImpl* variants delegate to; opts.ReadPolicy controls
+// whether GetTables/GetShards may serve from managerImpl's local cache.
+func NewManagerImplWithOptions(ctx context.Context, storage storage.Storage, hbstream *schedule.HeartbeatStreams, rootPath string, provider ClusterProvider, opts ManagerOptions) (Manager, error) {
+	// Cluster IDs are global, not scoped to any one cluster, so this
+	// allocator is constructed with no clusterName.
+	alloc := id.NewAllocatorImpl(storage, rootPath, "", AllocClusterIDPrefix)
+	watchCtx, watchCancel := context.WithCancel(context.Background())
 
-	manager := &managerImpl{storage: storage, alloc: alloc, clusters: make(map[string]*Cluster, 0), hbstreams: hbstream, rootPath: rootPath}
+	manager := &managerImpl{
+		storage: storage, alloc: alloc, clusters: make(map[string]*clusterEntry, 0), hbstreams: hbstream, rootPath: rootPath,
+		healthCheckInterval: defaultHealthCheckInterval, maxHealthCheckFailures: defaultMaxHealthCheckFailures,
+		stopHealthCheck: make(chan struct{}), provider: provider, opts: opts,
+		watchCtx: watchCtx, watchCancel: watchCancel,
+	}
 
-	clusters, err := manager.storage.ListClusters(ctx)
+	descriptors, err := provider.List(ctx)
 	if err != nil {
 		log.Error("new clusters manager failed, fail to list clusters", zap.Error(err))
 		return nil, errors.Wrap(err, "clusters manager list clusters")
 	}
 
 	manager.lock.Lock()
-	defer manager.lock.Unlock()
-
-	manager.clusters = make(map[string]*Cluster, len(clusters))
-	for _, clusterPb := range clusters {
-		cluster := NewCluster(clusterPb, manager.storage, manager.hbstreams, manager.rootPath)
-		if err := cluster.Load(ctx); err != nil {
+	manager.clusters = make(map[string]*clusterEntry, len(descriptors))
+	for _, descriptor := range descriptors {
+		cluster, err := provider.Load(ctx, descriptor.Name)
+		if err != nil {
 			log.Error("new clusters manager failed, fail to load cluster", zap.Error(err))
-			return nil, errors.Wrapf(err, "clusters manager Load, clusters:%v", cluster)
+			manager.lock.Unlock()
+			return nil, errors.Wrapf(err, "clusters manager Load, clusterName:%s", descriptor.Name)
 		}
-		manager.clusters[cluster.Name()] = cluster
+		manager.clusters[cluster.Name()] = newClusterEntry(cluster)
+	}
+	manager.lock.Unlock()
+
+	// Upgrade path: clusters created before ClusterUUID was introduced have
+	// none persisted yet, assign one now.
+	for _, entry := range manager.clusters {
+		if err := manager.ensureClusterUUID(ctx, entry.cluster); err != nil {
+			log.Error("new clusters manager failed, fail to assign cluster uuid", zap.Error(err))
+			return nil, errors.Wrapf(err, "clusters manager ensureClusterUUID, clusterName:%s", entry.cluster.Name())
+		}
+	}
+
+	manager.wg.Add(1)
+	go manager.runHealthCheckLoop()
+
+	manager.wg.Add(1)
+	go manager.runProviderWatchLoop(manager.watchCtx)
+
+	if opts.ReadPolicy.mode != readPolicyLinearizable {
+		manager.wg.Add(1)
+		go manager.runReconcileLoop()
 	}
 
 	return manager, nil
 }
 
+// ensureClusterUUID assigns cluster a fresh ClusterUUID if it doesn't
+// already have one persisted. Only the leader/metasrv holding the etcd
+// campaign should reach this path with a real write; the assignment itself
+// is a CAS against storage so that a UUID is assigned exactly once even if
+// multiple metasrv replicas race to upgrade the same cluster concurrently.
+func (m *managerImpl) ensureClusterUUID(ctx context.Context, cluster *Cluster) error {
+	if cluster.GetUUID() != "" {
+		return nil
+	}
+
+	newUUID := uuid.NewString()
+	if err := cluster.SetUUIDIfEmpty(ctx, newUUID); err != nil {
+		return errors.Wrapf(err, "ensureClusterUUID, clusterName:%s", cluster.Name())
+	}
+	return nil
+}
+
+// Close stops the health-check loop and the provider watch loop. It does
+// not evict or close any already-loaded cluster.
+func (m *managerImpl) Close() error {
+	close(m.stopHealthCheck)
+	m.watchCancel()
+	m.wg.Wait()
+	return nil
+}
+
 func (m *managerImpl) CreateCluster(ctx context.Context, clusterName string, initialNodeCount,
 	replicationFactor, shardTotal uint32,
 ) (*Cluster, error) {
@@ -111,6 +687,7 @@ func (m *managerImpl) CreateCluster(ctx context.Context, clusterName string, ini
 		MinNodeCount:      initialNodeCount,
 		ReplicationFactor: replicationFactor,
 		ShardTotal:        shardTotal,
+		Uuid:              uuid.NewString(),
 	}
 	clusterPb, err = m.storage.CreateCluster(ctx, clusterPb)
 	if err != nil {
@@ -130,18 +707,22 @@ func (m *managerImpl) CreateCluster(ctx context.Context, clusterName string, ini
 		return nil, errors.Wrapf(err, "clusters manager CreateCluster, clusterName:%s", clusterName)
 	}
 
-	m.clusters[clusterName] = cluster
+	m.clusters[clusterName] = newClusterEntry(cluster)
 
 	return cluster, nil
 }
 
 func (m *managerImpl) AllocSchemaID(ctx context.Context, clusterName, schemaName string) (uint32, error) {
-	cluster, err := m.getCluster(ctx, clusterName)
+	entry, err := m.getClusterEntry(ctx, clusterName)
 	if err != nil {
 		log.Error("cluster not found", zap.Error(err))
 		return 0, errors.Wrap(err, "clusters manager AllocSchemaID")
 	}
 
+	entry.lock.lock()
+	defer entry.lock.unlock()
+	cluster := entry.cluster
+
 	// create new schema
 	schema, err := cluster.GetOrCreateSchema(ctx, schemaName)
 	if err != nil {
@@ -152,36 +733,67 @@ func (m *managerImpl) AllocSchemaID(ctx context.Context, clusterName, schemaName
 	return schema.GetID(), nil
 }
 
+// AllocTableID is a thin wrapper around BatchAllocTableID preserving the
+// single-item semantics callers already depend on.
 func (m *managerImpl) AllocTableID(ctx context.Context, clusterName, schemaName, tableName, nodeName string) (*Table, error) {
-	cluster, err := m.getCluster(ctx, clusterName)
+	tables, err := m.BatchAllocTableID(ctx, clusterName, []TableSpec{
+		{SchemaName: schemaName, TableName: tableName, NodeName: nodeName},
+	})
 	if err != nil {
-		log.Error("cluster not found", zap.Error(err))
 		return nil, errors.Wrap(err, "clusters manager AllocTableID")
 	}
+	return tables[0], nil
+}
 
-	table, err := cluster.GetOrCreateTable(ctx, nodeName, schemaName, tableName)
+// BatchAllocTableID reserves a contiguous range from the table-ID allocator
+// in a single etcd txn, persists all resulting clusterpb.Table records in
+// one batched write, and flushes the resulting shard-assignment events to
+// hbstreams once, instead of once per table. The returned slice is
+// index-aligned with specs: tables[i] is nil if specs[i] failed, with the
+// underlying error recorded in the returned *BatchError.
+func (m *managerImpl) BatchAllocTableID(ctx context.Context, clusterName string, specs []TableSpec) ([]*Table, error) {
+	entry, err := m.getClusterEntry(ctx, clusterName)
 	if err != nil {
-		log.Error("fail to create table", zap.Error(err))
-		return nil, errors.Wrapf(err, "clusters manager AllocTableID, "+
-			"clusterName:%s, schemaName:%s, tableName:%s, nodeName:%s", clusterName, schemaName, tableName, nodeName)
+		log.Error("cluster not found", zap.Error(err))
+		return nil, errors.Wrap(err, "clusters manager BatchAllocTableID")
+	}
+
+	entry.lock.lock()
+	defer entry.lock.unlock()
+
+	tables, errs := entry.cluster.BatchGetOrCreateTables(ctx, specs)
+	entry.invalidateCaches()
+	if batchErr := newBatchError(errs); batchErr != nil {
+		log.Error("fail to batch create table", zap.Error(batchErr))
+		return tables, errors.Wrapf(batchErr, "clusters manager BatchAllocTableID, clusterName:%s", clusterName)
 	}
-	return table, nil
+	return tables, nil
 }
 
+// GetTables serves from entry.tableCache, instead of reading the cluster
+// under entry.lock, whenever m.opts.ReadPolicy allows it; see ReadPolicy.
 func (m *managerImpl) GetTables(ctx context.Context, clusterName, nodeName string, shardIDs []uint32) (map[uint32]*ShardTables, error) {
-	cluster, err := m.getCluster(ctx, clusterName)
+	entry, err := m.getClusterEntry(ctx, clusterName)
 	if err != nil {
 		log.Error("cluster not found", zap.Error(err))
 		return nil, errors.Wrap(err, "clusters manager GetTables")
 	}
 
+	if ret, ok := m.cachedGetTables(entry, nodeName, shardIDs); ok {
+		return ret, nil
+	}
+
+	entry.lock.lock()
+	defer entry.lock.unlock()
+	cluster := entry.cluster
+
 	shardTablesWithRole, err := cluster.GetTables(ctx, shardIDs, nodeName)
 	if err != nil {
 		return nil, errors.Wrapf(err, "clusters manager GetTables, "+
 			"clusterName:%s, nodeName:%s, shardIDs:%v", clusterName, nodeName, shardIDs)
 	}
 
-	ret := make(map[uint32]*ShardTables, len(shardIDs))
+	ret := make(map[uint32]*ShardTables, len(shardTablesWithRole))
 	for shardID, shardTables := range shardTablesWithRole {
 		tableInfos := make([]*TableInfo, 0, len(shardTables.tables))
 
@@ -193,30 +805,85 @@ func (m *managerImpl) GetTables(ctx context.Context, clusterName, nodeName strin
 		}
 		ret[shardID] = &ShardTables{ShardRole: shardTables.shardRole, Tables: tableInfos, Version: shardTables.version}
 	}
+	if m.opts.ReadPolicy.mode != readPolicyLinearizable {
+		entry.tableCache.set(nodeName, ret)
+	}
 	return ret, nil
 }
 
+// cachedGetTables returns a cached GetTables result if m.opts.ReadPolicy
+// permits a non-linearizable read and the cache is populated for nodeName
+// (and, for StaleBoundedBy, fresh enough).
+func (m *managerImpl) cachedGetTables(entry *clusterEntry, nodeName string, shardIDs []uint32) (map[uint32]*ShardTables, bool) {
+	if m.opts.ReadPolicy.mode == readPolicyLinearizable {
+		return nil, false
+	}
+
+	data, ok := entry.tableCache.get(nodeName, m.opts.ReadPolicy.staleBound())
+	if !ok {
+		return nil, false
+	}
+	if len(shardIDs) == 0 {
+		return data, true
+	}
+
+	ret := make(map[uint32]*ShardTables, len(shardIDs))
+	for _, shardID := range shardIDs {
+		if shardTables, ok := data[shardID]; ok {
+			ret[shardID] = shardTables
+		}
+	}
+	return ret, true
+}
+
+// DropTable is a thin wrapper around BatchDropTable preserving the
+// single-item semantics callers already depend on.
 func (m *managerImpl) DropTable(ctx context.Context, clusterName, schemaName, tableName string, tableID uint64) error {
-	cluster, err := m.getCluster(ctx, clusterName)
+	err := m.BatchDropTable(ctx, clusterName, []TableRef{
+		{SchemaName: schemaName, TableName: tableName, TableID: tableID},
+	})
+	return errors.Wrap(err, "clusters manager DropTable")
+}
+
+// BatchDropTable takes the per-cluster lock once, drops every ref in a
+// single batched write, and reports which refs failed and why via the
+// returned *BatchError.
+func (m *managerImpl) BatchDropTable(ctx context.Context, clusterName string, refs []TableRef) error {
+	entry, err := m.getClusterEntry(ctx, clusterName)
 	if err != nil {
 		log.Error("cluster not found", zap.Error(err))
-		return errors.Wrap(err, "clusters manager DropTable")
+		return errors.Wrap(err, "clusters manager BatchDropTable")
 	}
 
-	if err := cluster.DropTable(ctx, schemaName, tableName, tableID); err != nil {
-		return errors.Wrapf(err, "clusters manager DropTable, clusterName:%s, schemaName:%s, tableName:%s, tableID:%d",
-			clusterName, schemaName, tableName, tableID)
-	}
+	entry.lock.lock()
+	defer entry.lock.unlock()
 
+	errs := entry.cluster.BatchDropTables(ctx, refs)
+	entry.invalidateCaches()
+	if batchErr := newBatchError(errs); batchErr != nil {
+		log.Error("fail to batch drop table", zap.Error(batchErr))
+		return errors.Wrapf(batchErr, "clusters manager BatchDropTable, clusterName:%s", clusterName)
+	}
 	return nil
 }
 
 func (m *managerImpl) RegisterNode(ctx context.Context, clusterName, nodeName string, lease uint32) error {
-	cluster, err := m.getCluster(ctx, clusterName)
+	entry, err := m.getClusterEntry(ctx, clusterName)
 	if err != nil {
 		log.Error("cluster not found", zap.Error(err))
 		return errors.Wrap(err, "clusters manager RegisterNode")
 	}
+
+	// RegisterNode triggers scatterShard below, which is expensive, so it
+	// never queues behind another in-flight call for the same cluster:
+	// callers for a busy cluster get ErrClusterBusy immediately instead of
+	// blocking, while calls against other clusters proceed in parallel.
+	if !entry.lock.tryLock() {
+		return ErrClusterBusy.WithCausef("clusters manager RegisterNode, clusterName:%s", clusterName)
+	}
+	defer entry.lock.unlock()
+	cluster := entry.cluster
+
 	err = cluster.RegisterNode(ctx, nodeName, lease)
 	if err != nil {
 		return errors.Wrap(err, "clusters manager RegisterNode")
@@ -226,33 +893,565 @@ func (m *managerImpl) RegisterNode(ctx context.Context, clusterName, nodeName st
 	if err := cluster.coordinator.scatterShard(ctx); err != nil {
 		return errors.Wrap(err, "RegisterNode")
 	}
+	entry.invalidateCaches()
 	return nil
 }
 
+// GetShards serves from entry.shardsCache, instead of reading the cluster
+// under entry.lock, whenever m.opts.ReadPolicy allows it; see ReadPolicy.
 func (m *managerImpl) GetShards(ctx context.Context, clusterName, nodeName string) ([]uint32, error) {
-	cluster, err := m.getCluster(ctx, clusterName)
+	entry, err := m.getClusterEntry(ctx, clusterName)
 	if err != nil {
 		log.Error("cluster not found", zap.Error(err))
 		return nil, errors.Wrap(err, "clusters manager GetShards")
 	}
 
-	shardIDs, err := cluster.GetShardIDs(nodeName)
+	if m.opts.ReadPolicy.mode != readPolicyLinearizable {
+		if shardIDs, ok := entry.shardsCache.get(nodeName, m.opts.ReadPolicy.staleBound()); ok {
+			return shardIDs, nil
+		}
+	}
+
+	entry.lock.lock()
+	defer entry.lock.unlock()
+
+	shardIDs, err := entry.cluster.GetShardIDs(nodeName)
 	if err != nil {
 		return nil, errors.Wrap(err, "clusters manager GetShards")
 	}
+	if m.opts.ReadPolicy.mode != readPolicyLinearizable {
+		entry.shardsCache.set(nodeName, shardIDs)
+	}
 	return shardIDs, nil
 }
 
-func (m *managerImpl) getCluster(_ context.Context, clusterName string) (*Cluster, error) {
+// getClusterEntry looks up the clusterEntry for clusterName, holding the
+// top-level map lock only long enough to find it. Work against the
+// returned entry's cluster must go through entry.lock, not m.lock, so
+// that operations on different clusters never serialize on each other. On
+// a cache miss (e.g. checkClusterHealth having evicted the cluster, or a
+// cluster created by another metasrv replica that this process hasn't seen
+// a ClusterEvent for yet) it falls back to m.provider.Load, so eviction is
+// not terminal: the next call simply re-loads the cluster from storage.
+func (m *managerImpl) getClusterEntry(ctx context.Context, clusterName string) (*clusterEntry, error) {
 	m.lock.RLock()
-	cluster, ok := m.clusters[clusterName]
+	entry, ok := m.clusters[clusterName]
 	m.lock.RUnlock()
-	if !ok {
-		return nil, ErrClusterNotFound.WithCausef("clusters manager getCluster, clusterName:%s", clusterName)
+	if ok {
+		return entry, nil
+	}
+
+	cluster, err := m.provider.Load(ctx, clusterName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "clusters manager getCluster, clusterName:%s", clusterName)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if entry, ok := m.clusters[clusterName]; ok {
+		// Lost the race against a concurrent reload of the same cluster;
+		// keep the one already installed rather than replacing it with a
+		// second, independently-loaded Cluster.
+		return entry, nil
+	}
+	entry = newClusterEntry(cluster)
+	m.clusters[clusterName] = entry
+	return entry, nil
+}
+
+// ClusterHealth returns the result of the most recent health check run
+// against clusterName, including the last check time, the number of
+// consecutive failures observed so far, and the lease status of every node
+// registered with the cluster.
+func (m *managerImpl) ClusterHealth(ctx context.Context, clusterName string) (HealthStatus, error) {
+	entry, err := m.getClusterEntry(ctx, clusterName)
+	if err != nil {
+		return HealthStatus{}, errors.Wrap(err, "clusters manager ClusterHealth")
+	}
+
+	entry.healthMu.Lock()
+	defer entry.healthMu.Unlock()
+	return entry.health, nil
+}
+
+// GetClusterUUID returns the cluster's persisted ClusterUUID so that
+// clients can detect they've reconnected to a re-created cluster with the
+// same name and refuse to serve stale shards.
+func (m *managerImpl) GetClusterUUID(ctx context.Context, clusterName string) (string, error) {
+	entry, err := m.getClusterEntry(ctx, clusterName)
+	if err != nil {
+		return "", errors.Wrap(err, "clusters manager GetClusterUUID")
+	}
+	return entry.cluster.GetUUID(), nil
+}
+
+// Snapshot serializes clusterName's full state to w. It takes the
+// per-cluster lock for the duration of the export so the snapshot reflects
+// a single consistent point in time.
+func (m *managerImpl) Snapshot(ctx context.Context, clusterName string, w io.Writer) error {
+	entry, err := m.getClusterEntry(ctx, clusterName)
+	if err != nil {
+		return errors.Wrap(err, "clusters manager Snapshot")
+	}
+
+	entry.lock.lock()
+	defer entry.lock.unlock()
+
+	state, err := entry.cluster.ExportState(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "clusters manager Snapshot, clusterName:%s", clusterName)
+	}
+
+	if err := writeSnapshot(w, state); err != nil {
+		return errors.Wrapf(err, "clusters manager Snapshot, clusterName:%s", clusterName)
+	}
+	return nil
+}
+
+// Restore recreates a cluster from a snapshot read from r. The restored
+// cluster is persisted via a single storage transaction that also bumps
+// the schema/table ID allocator watermarks past the max ID seen in the
+// snapshot, so that freshly allocated IDs never collide with restored
+// ones.
+func (m *managerImpl) Restore(ctx context.Context, r io.Reader) (*Cluster, error) {
+	state, err := readSnapshot(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "clusters manager Restore")
+	}
+
+	clusterPb, err := m.storage.RestoreCluster(ctx, state.Cluster, state.Schemas, state.Tables, state.Shards, state.Nodes,
+		state.SchemaIDAllocWatermark, state.TableIDAllocWatermark)
+	if err != nil {
+		return nil, errors.Wrapf(err, "clusters manager Restore, clusterName:%s", state.Cluster.GetName())
 	}
+
+	cluster := NewCluster(clusterPb, m.storage, m.hbstreams, m.rootPath)
+	if err := cluster.Load(ctx); err != nil {
+		return nil, errors.Wrapf(err, "clusters manager Restore, clusterName:%s", clusterPb.GetName())
+	}
+
+	m.lock.Lock()
+	m.clusters[cluster.Name()] = newClusterEntry(cluster)
+	m.lock.Unlock()
+
 	return cluster, nil
 }
 
+// writeSnapshot encodes state as a header (magic, format version,
+// ClusterUUID) followed by one length-prefixed, CRC32C-checked frame per
+// proto record.
+func writeSnapshot(w io.Writer, state *ClusterState) error {
+	if err := binary.Write(w, binary.BigEndian, snapshotMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, snapshotFormatVersion); err != nil {
+		return err
+	}
+	if err := writeLengthPrefixed(w, []byte(state.Cluster.GetUuid())); err != nil {
+		return err
+	}
+
+	if err := writeFrame(w, frameTypeCluster, state.Cluster); err != nil {
+		return err
+	}
+	for _, schema := range state.Schemas {
+		if err := writeFrame(w, frameTypeSchema, schema); err != nil {
+			return err
+		}
+	}
+	for _, table := range state.Tables {
+		if err := writeFrame(w, frameTypeTable, table); err != nil {
+			return err
+		}
+	}
+	for _, shard := range state.Shards {
+		if err := writeFrame(w, frameTypeShard, shard); err != nil {
+			return err
+		}
+	}
+	for _, node := range state.Nodes {
+		if err := writeFrame(w, frameTypeNode, node); err != nil {
+			return err
+		}
+	}
+
+	watermarks := make([]byte, 16)
+	binary.BigEndian.PutUint64(watermarks[0:8], state.SchemaIDAllocWatermark)
+	binary.BigEndian.PutUint64(watermarks[8:16], state.TableIDAllocWatermark)
+	return writeRawFrame(w, frameTypeAllocWatermarks, watermarks)
+}
+
+// readSnapshot decodes a ClusterState written by writeSnapshot, rejecting
+// unknown magic/version and any frame whose CRC32C doesn't match.
+func readSnapshot(r io.Reader) (*ClusterState, error) {
+	var magic, version uint32
+	if err := binary.Read(r, binary.BigEndian, &magic); err != nil {
+		return nil, errors.Wrap(err, "read snapshot header")
+	}
+	if magic != snapshotMagic {
+		return nil, errors.Errorf("not a cluster snapshot, bad magic:%x", magic)
+	}
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, errors.Wrap(err, "read snapshot header")
+	}
+	if version != snapshotFormatVersion {
+		return nil, errors.Errorf("unsupported snapshot format version:%d", version)
+	}
+	if _, err := readLengthPrefixed(r); err != nil { // ClusterUUID, re-derived from the cluster frame below
+		return nil, errors.Wrap(err, "read snapshot header")
+	}
+
+	state := &ClusterState{}
+	for {
+		frameType, payload, err := readRawFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "read snapshot frame")
+		}
+
+		switch frameType {
+		case frameTypeCluster:
+			state.Cluster = &clusterpb.Cluster{}
+			if err := proto.Unmarshal(payload, state.Cluster); err != nil {
+				return nil, errors.Wrap(err, "decode cluster frame")
+			}
+		case frameTypeSchema:
+			schema := &clusterpb.Schema{}
+			if err := proto.Unmarshal(payload, schema); err != nil {
+				return nil, errors.Wrap(err, "decode schema frame")
+			}
+			state.Schemas = append(state.Schemas, schema)
+		case frameTypeTable:
+			table := &clusterpb.Table{}
+			if err := proto.Unmarshal(payload, table); err != nil {
+				return nil, errors.Wrap(err, "decode table frame")
+			}
+			state.Tables = append(state.Tables, table)
+		case frameTypeShard:
+			shard := &clusterpb.Shard{}
+			if err := proto.Unmarshal(payload, shard); err != nil {
+				return nil, errors.Wrap(err, "decode shard frame")
+			}
+			state.Shards = append(state.Shards, shard)
+		case frameTypeNode:
+			node := &clusterpb.Node{}
+			if err := proto.Unmarshal(payload, node); err != nil {
+				return nil, errors.Wrap(err, "decode node frame")
+			}
+			state.Nodes = append(state.Nodes, node)
+		case frameTypeAllocWatermarks:
+			if len(payload) != 16 {
+				return nil, errors.Errorf("malformed allocator watermark frame, len:%d", len(payload))
+			}
+			state.SchemaIDAllocWatermark = binary.BigEndian.Uint64(payload[0:8])
+			state.TableIDAllocWatermark = binary.BigEndian.Uint64(payload[8:16])
+		default:
+			return nil, errors.Errorf("unknown snapshot frame type:%d", frameType)
+		}
+	}
+
+	if state.Cluster == nil {
+		return nil, errors.Errorf("snapshot is missing its cluster frame")
+	}
+	return state, nil
+}
+
+func writeFrame(w io.Writer, frameType uint8, msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "marshal snapshot frame")
+	}
+	return writeRawFrame(w, frameType, payload)
+}
+
+func writeRawFrame(w io.Writer, frameType uint8, payload []byte) error {
+	if err := binary.Write(w, binary.BigEndian, frameType); err != nil {
+		return err
+	}
+	if err := writeLengthPrefixed(w, payload); err != nil {
+		return err
+	}
+	checksum := crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli))
+	return binary.Write(w, binary.BigEndian, checksum)
+}
+
+func readRawFrame(r io.Reader) (uint8, []byte, error) {
+	var frameType uint8
+	if err := binary.Read(r, binary.BigEndian, &frameType); err != nil {
+		return 0, nil, err
+	}
+	payload, err := readLengthPrefixed(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var checksum uint32
+	if err := binary.Read(r, binary.BigEndian, &checksum); err != nil {
+		return 0, nil, err
+	}
+	if want := crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli)); want != checksum {
+		return 0, nil, errors.Errorf("snapshot frame checksum mismatch, type:%d, want:%x, got:%x", frameType, want, checksum)
+	}
+	return frameType, payload, nil
+}
+
+func writeLengthPrefixed(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// runHealthCheckLoop periodically probes every loaded cluster until Close
+// is called.
+func (m *managerImpl) runHealthCheckLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.checkClustersHealth(context.Background())
+		case <-m.stopHealthCheck:
+			return
+		}
+	}
+}
+
+// runProviderWatchLoop reacts to out-of-band cluster creation/deletion
+// reported by m.provider until Close is called.
+func (m *managerImpl) runProviderWatchLoop(ctx context.Context) {
+	defer m.wg.Done()
+
+	events, err := m.provider.Watch(ctx)
+	if err != nil {
+		log.Error("cluster provider watch failed, out-of-band cluster changes won't be observed", zap.Error(err))
+		return
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			m.handleClusterEvent(ctx, event)
+		case <-m.stopHealthCheck:
+			return
+		}
+	}
+}
+
+// handleClusterEvent lazily Loads a cluster added out-of-band and caches
+// it, or evicts one removed out-of-band.
+func (m *managerImpl) handleClusterEvent(ctx context.Context, event ClusterEvent) {
+	switch event.Type {
+	case ClusterEventAdded:
+		m.lock.RLock()
+		_, exists := m.clusters[event.Name]
+		m.lock.RUnlock()
+		if exists {
+			return
+		}
+
+		cluster, err := m.provider.Load(ctx, event.Name)
+		if err != nil {
+			log.Error("fail to lazily load cluster added out-of-band", zap.String("cluster", event.Name), zap.Error(err))
+			return
+		}
+
+		m.lock.Lock()
+		m.clusters[event.Name] = newClusterEntry(cluster)
+		m.lock.Unlock()
+	case ClusterEventRemoved:
+		m.lock.Lock()
+		entry, ok := m.clusters[event.Name]
+		if ok {
+			delete(m.clusters, event.Name)
+		}
+		m.lock.Unlock()
+		if !ok {
+			return
+		}
+
+		entry.lock.lock()
+		defer entry.lock.unlock()
+		if err := entry.cluster.Close(); err != nil {
+			log.Error("fail to close cluster removed out-of-band", zap.String("cluster", event.Name), zap.Error(err))
+		}
+	}
+}
+
+// runReconcileLoop periodically refreshes every cluster's cached shard
+// topology from storage, so that writes made by another metasrv replica
+// are eventually observed even though they can't invalidate this
+// process's in-memory cache directly.
+func (m *managerImpl) runReconcileLoop() {
+	defer m.wg.Done()
+
+	interval := defaultReconcileInterval
+	if bound := m.opts.ReadPolicy.staleBound(); bound > 0 {
+		interval = bound
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.reconcileCachedViews(context.Background())
+		case <-m.stopHealthCheck:
+			return
+		}
+	}
+}
+
+// reconcileCachedViews refreshes the table cache of every cluster whose
+// per-cluster lock isn't currently held by foreground work; a busy
+// cluster is simply left for the next tick.
+func (m *managerImpl) reconcileCachedViews(ctx context.Context) {
+	m.lock.RLock()
+	entries := make([]*clusterEntry, 0, len(m.clusters))
+	for _, entry := range m.clusters {
+		entries = append(entries, entry)
+	}
+	m.lock.RUnlock()
+
+	for _, entry := range entries {
+		m.reconcileClusterCache(ctx, entry)
+	}
+}
+
+// reconcileClusterCache reloads entry.cluster from storage first, so that a
+// write made directly by another metasrv replica is actually observed
+// instead of this process only ever re-deriving the cache from its own
+// stale in-memory state. It then drops every cached entry (per-node
+// GetTables views and all GetShards results, which GetTables/GetShards
+// re-derive on demand from the reloaded state) and repopulates the entry
+// keyed by the empty nodeName, i.e. the "every node's tables" view.
+func (m *managerImpl) reconcileClusterCache(ctx context.Context, entry *clusterEntry) {
+	if !entry.lock.tryLock() {
+		return
+	}
+	defer entry.lock.unlock()
+
+	if err := entry.cluster.Load(ctx); err != nil {
+		log.Error("fail to reload cluster from storage", zap.String("cluster", entry.cluster.Name()), zap.Error(err))
+		return
+	}
+	entry.invalidateCaches()
+
+	shardTablesWithRole, err := entry.cluster.GetTables(ctx, nil, "")
+	if err != nil {
+		log.Error("fail to reconcile cached shard tables", zap.String("cluster", entry.cluster.Name()), zap.Error(err))
+		return
+	}
+
+	ret := make(map[uint32]*ShardTables, len(shardTablesWithRole))
+	for shardID, shardTables := range shardTablesWithRole {
+		tableInfos := make([]*TableInfo, 0, len(shardTables.tables))
+		for _, t := range shardTables.tables {
+			tableInfos = append(tableInfos, &TableInfo{
+				ID: t.meta.GetId(), Name: t.meta.GetName(),
+				SchemaID: t.schema.GetId(), SchemaName: t.schema.GetName(),
+			})
+		}
+		ret[shardID] = &ShardTables{ShardRole: shardTables.shardRole, Tables: tableInfos, Version: shardTables.version}
+	}
+	entry.tableCache.set("", ret)
+}
+
+// checkClustersHealth probes every currently loaded cluster and evicts any
+// cluster that has reached maxHealthCheckFailures consecutive failures.
+func (m *managerImpl) checkClustersHealth(ctx context.Context) {
+	m.lock.RLock()
+	entries := make(map[string]*clusterEntry, len(m.clusters))
+	for name, entry := range m.clusters {
+		entries[name] = entry
+	}
+	m.lock.RUnlock()
+
+	for name, entry := range entries {
+		if evict := m.checkClusterHealth(ctx, entry); evict {
+			m.evictCluster(name, entry)
+		}
+	}
+}
+
+// checkClusterHealth probes a single cluster's storage reachability,
+// heartbeat freshness of its registered nodes, and etcd session liveness.
+// The probe runs under entry.lock via tryLock so that it never blocks (and
+// is never blocked by) foreground work against the same cluster; a busy
+// cluster is simply skipped and retried on the next tick.
+// It reports whether the cluster should now be evicted.
+func (m *managerImpl) checkClusterHealth(ctx context.Context, entry *clusterEntry) bool {
+	if !entry.lock.tryLock() {
+		return false
+	}
+	defer entry.lock.unlock()
+
+	leases, leaseErr := entry.cluster.NodeLeaseStatuses(ctx)
+	if leaseErr != nil {
+		log.Error("health check failed", zap.String("cluster", entry.cluster.Name()), zap.Error(leaseErr))
+	}
+
+	// GetCluster round-trips to storage (etcd), so a cluster whose etcd
+	// session has died is still caught even if every node's lease, which is
+	// tracked purely in this process's memory, still looks fresh.
+	_, storageErr := m.storage.GetCluster(ctx, entry.cluster.Name())
+	if storageErr != nil {
+		log.Error("health check storage probe failed", zap.String("cluster", entry.cluster.Name()), zap.Error(storageErr))
+	}
+
+	entry.healthMu.Lock()
+	defer entry.healthMu.Unlock()
+	entry.health.LastCheckTime = time.Now()
+	entry.health.NodeLeases = leases
+	if leaseErr != nil || storageErr != nil {
+		entry.health.FailureCount++
+	} else {
+		entry.health.FailureCount = 0
+	}
+	return entry.health.FailureCount >= m.maxHealthCheckFailures
+}
+
+// evictCluster removes clusterName from the cache and releases its
+// coordinator/heartbeat resources. It takes entry.lock first so that the
+// eviction is never concurrent with an in-flight operation on the cluster;
+// subsequent getClusterEntry calls re-load the cluster from storage.
+func (m *managerImpl) evictCluster(clusterName string, entry *clusterEntry) {
+	entry.lock.lock()
+	defer entry.lock.unlock()
+
+	log.Warn("evicting unhealthy cluster", zap.String("cluster", clusterName))
+	if err := entry.cluster.Close(); err != nil {
+		log.Error("fail to close evicted cluster", zap.String("cluster", clusterName), zap.Error(err))
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if current, ok := m.clusters[clusterName]; ok && current == entry {
+		delete(m.clusters, clusterName)
+	}
+}
+
 func (m *managerImpl) allocClusterID(ctx context.Context) (uint32, error) {
 	ID, err := m.alloc.Alloc(ctx)
 	if err != nil {
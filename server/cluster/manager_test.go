@@ -0,0 +1,341 @@
+// Copyright 2022 CeresDB Project Authors. Licensed under Apache-2.0.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/ceresdbproto/pkg/clusterpb"
+	"github.com/CeresDB/ceresmeta/server/schedule"
+	"github.com/CeresDB/ceresmeta/server/storage"
+	"github.com/pkg/errors"
+)
+
+// TestClusterEntryLocksAreIndependent asserts that clusterEntry.lock is
+// scoped per cluster: holding one cluster's lock must never block another
+// cluster's lock from being acquired, since that would serialize unrelated
+// clusters' work behind each other.
+func TestClusterEntryLocksAreIndependent(t *testing.T) {
+	entryA := newClusterEntry(NewCluster(&clusterpb.Cluster{Name: "clusterA"}, storage.NewMemoryStorage("/test"), schedule.NewHeartbeatStreams(), "/test"))
+	entryB := newClusterEntry(NewCluster(&clusterpb.Cluster{Name: "clusterB"}, storage.NewMemoryStorage("/test"), schedule.NewHeartbeatStreams(), "/test"))
+
+	entryA.lock.lock()
+	defer entryA.lock.unlock()
+
+	done := make(chan struct{})
+	go func() {
+		entryB.lock.lock()
+		defer entryB.lock.unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("acquiring clusterB's lock blocked on clusterA's lock; per-cluster locks must not serialize distinct clusters")
+	}
+}
+
+// TestSetUUIDIfEmptyAssignsExactlyOnce simulates several metasrv replicas
+// independently loading the same just-created cluster and racing to assign
+// its ClusterUUID (the ensureClusterUUID upgrade path run by every
+// NewManagerImplWithOptions). Exactly one UUID must win, and every replica
+// must end up agreeing on it.
+func TestSetUUIDIfEmptyAssignsExactlyOnce(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemoryStorage("/test")
+
+	if _, err := s.CreateCluster(ctx, &clusterpb.Cluster{Name: "clusterA", ShardTotal: 4}); err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+
+	const replicas = 20
+	observed := make([]string, replicas)
+
+	var wg sync.WaitGroup
+	for i := 0; i < replicas; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Each replica independently loads its own copy of the cluster
+			// record, as it would from etcd, then races to upgrade it.
+			loaded, err := s.GetCluster(ctx, "clusterA")
+			if err != nil {
+				t.Errorf("replica %d: GetCluster: %v", i, err)
+				return
+			}
+			cluster := NewCluster(loaded, s, schedule.NewHeartbeatStreams(), "/test")
+
+			if err := cluster.SetUUIDIfEmpty(ctx, fmt.Sprintf("uuid-%d", i)); err != nil {
+				t.Errorf("replica %d: SetUUIDIfEmpty: %v", i, err)
+				return
+			}
+			observed[i] = cluster.GetUUID()
+		}(i)
+	}
+	wg.Wait()
+
+	want := observed[0]
+	if want == "" {
+		t.Fatal("expected a UUID to be assigned, got empty string")
+	}
+	for i, uuid := range observed {
+		if uuid != want {
+			t.Fatalf("replica %d observed UUID %q, want %q; UUID must be assigned exactly once across all replicas", i, uuid, want)
+		}
+	}
+
+	persisted, err := s.GetCluster(ctx, "clusterA")
+	if err != nil {
+		t.Fatalf("GetCluster: %v", err)
+	}
+	if persisted.GetUuid() != want {
+		t.Fatalf("persisted UUID %q does not match the UUID every replica observed %q", persisted.GetUuid(), want)
+	}
+}
+
+// countingStorage wraps a storage.Storage, counting calls to Put and
+// BatchPut so tests can assert that a batch operation writes through
+// BatchPut exactly once instead of once per item via Put.
+type countingStorage struct {
+	storage.Storage
+
+	mu        sync.Mutex
+	puts      int
+	batchPuts int
+}
+
+func (s *countingStorage) Put(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	s.puts++
+	s.mu.Unlock()
+	return s.Storage.Put(ctx, key, value)
+}
+
+func (s *countingStorage) BatchPut(ctx context.Context, kvs map[string]string) error {
+	s.mu.Lock()
+	s.batchPuts++
+	s.mu.Unlock()
+	return s.Storage.BatchPut(ctx, kvs)
+}
+
+// TestBatchGetOrCreateTablesWritesThroughBatchPutOnce asserts that creating
+// many tables in one BatchGetOrCreateTables call persists them via a
+// single storage.BatchPut, not one storage.Put per table.
+func TestBatchGetOrCreateTablesWritesThroughBatchPutOnce(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingStorage{Storage: storage.NewMemoryStorage("/test")}
+	cluster := NewCluster(&clusterpb.Cluster{Name: "clusterA", ShardTotal: 4}, counting, schedule.NewHeartbeatStreams(), "/test")
+
+	if _, err := cluster.GetOrCreateSchema(ctx, "schemaA"); err != nil {
+		t.Fatalf("GetOrCreateSchema: %v", err)
+	}
+
+	counting.mu.Lock()
+	counting.puts, counting.batchPuts = 0, 0
+	counting.mu.Unlock()
+
+	const batchSize = 20
+	specs := make([]TableSpec, batchSize)
+	for i := range specs {
+		specs[i] = TableSpec{SchemaName: "schemaA", TableName: fmt.Sprintf("table%d", i), NodeName: "node0"}
+	}
+
+	tables, errs := cluster.BatchGetOrCreateTables(ctx, specs)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("spec %d: %v", i, err)
+		}
+	}
+	if len(tables) != batchSize {
+		t.Fatalf("got %d tables, want %d", len(tables), batchSize)
+	}
+
+	counting.mu.Lock()
+	defer counting.mu.Unlock()
+	if counting.batchPuts != 1 {
+		t.Fatalf("BatchPut called %d times for a %d-table batch, want exactly 1", counting.batchPuts, batchSize)
+	}
+	if counting.puts != 0 {
+		t.Fatalf("Put called %d times for a %d-table batch, want 0: all batch writes must go through BatchPut", counting.puts, batchSize)
+	}
+}
+
+// TestGetClusterEntryReloadsOnCacheMiss asserts that a cache miss (e.g. a
+// cluster just evicted by checkClusterHealth, or one created by another
+// metasrv replica that this process hasn't seen a ClusterEvent for yet) is
+// not terminal: getClusterEntry must fall back to provider.Load and install
+// the result, rather than only ever consulting the in-memory map.
+func TestGetClusterEntryReloadsOnCacheMiss(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemoryStorage("/test")
+	clusterPb, err := s.CreateCluster(ctx, &clusterpb.Cluster{Name: "clusterA", ShardTotal: 4})
+	if err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+	cluster := NewCluster(clusterPb, s, schedule.NewHeartbeatStreams(), "/test")
+
+	provider := newInMemoryClusterProvider()
+	provider.AddCluster(cluster)
+
+	m, err := NewManagerImplWithProvider(ctx, s, schedule.NewHeartbeatStreams(), "/test", provider)
+	if err != nil {
+		t.Fatalf("NewManagerImplWithProvider: %v", err)
+	}
+	manager := m.(*managerImpl)
+
+	// Simulate eviction: drop clusterA from the in-memory map without
+	// telling the provider, as checkClusterHealth does.
+	manager.lock.Lock()
+	delete(manager.clusters, "clusterA")
+	manager.lock.Unlock()
+
+	entry, err := manager.getClusterEntry(ctx, "clusterA")
+	if err != nil {
+		t.Fatalf("getClusterEntry: %v", err)
+	}
+	if entry.cluster.Name() != "clusterA" {
+		t.Fatalf("got cluster %q, want clusterA", entry.cluster.Name())
+	}
+
+	manager.lock.RLock()
+	_, reinstalled := manager.clusters["clusterA"]
+	manager.lock.RUnlock()
+	if !reinstalled {
+		t.Fatal("getClusterEntry did not reinstall the reloaded entry into manager.clusters")
+	}
+}
+
+// getClusterFailingStorage wraps a storage.Storage, making GetCluster fail
+// once failing is set, so tests can simulate an etcd session dying after a
+// manager has already started up against it.
+type getClusterFailingStorage struct {
+	storage.Storage
+
+	mu      sync.Mutex
+	failing bool
+}
+
+func (s *getClusterFailingStorage) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *getClusterFailingStorage) GetCluster(ctx context.Context, clusterName string) (*clusterpb.Cluster, error) {
+	s.mu.Lock()
+	failing := s.failing
+	s.mu.Unlock()
+	if failing {
+		return nil, errors.New("simulated storage outage")
+	}
+	return s.Storage.GetCluster(ctx, clusterName)
+}
+
+// TestCheckClusterHealthFailsOnStorageError asserts that checkClusterHealth
+// counts a storage reachability failure even when every node's in-memory
+// lease still looks fresh, since a dead etcd session is otherwise invisible
+// to NodeLeaseStatuses alone.
+func TestCheckClusterHealthFailsOnStorageError(t *testing.T) {
+	ctx := context.Background()
+	failing := &getClusterFailingStorage{Storage: storage.NewMemoryStorage("/test")}
+	clusterPb, err := failing.CreateCluster(ctx, &clusterpb.Cluster{Name: "clusterA", ShardTotal: 4})
+	if err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+	cluster := NewCluster(clusterPb, failing, schedule.NewHeartbeatStreams(), "/test")
+
+	provider := newInMemoryClusterProvider()
+	provider.AddCluster(cluster)
+
+	m, err := NewManagerImplWithProvider(ctx, failing, schedule.NewHeartbeatStreams(), "/test", provider)
+	if err != nil {
+		t.Fatalf("NewManagerImplWithProvider: %v", err)
+	}
+	manager := m.(*managerImpl)
+	manager.maxHealthCheckFailures = 1
+
+	entry, err := manager.getClusterEntry(ctx, "clusterA")
+	if err != nil {
+		t.Fatalf("getClusterEntry: %v", err)
+	}
+
+	// Simulate the etcd session dying after startup: every node's lease is
+	// still fresh in memory, but storage is now unreachable.
+	failing.setFailing(true)
+	if evict := manager.checkClusterHealth(ctx, entry); !evict {
+		t.Fatal("checkClusterHealth did not flag the cluster for eviction after a storage probe failure")
+	}
+}
+
+// TestReconcileClusterCacheObservesStorageWrite asserts that
+// reconcileClusterCache reloads entry.cluster from storage before
+// re-deriving the cache, so a table created by a second Cluster instance
+// writing to the same storage (simulating another metasrv replica) is
+// picked up instead of this process only ever re-deriving its cache from
+// its own stale in-memory state.
+func TestReconcileClusterCacheObservesStorageWrite(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemoryStorage("/test")
+	clusterPb, err := s.CreateCluster(ctx, &clusterpb.Cluster{Name: "clusterA", ShardTotal: 4})
+	if err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+
+	// clusterA is this process's view; otherCluster simulates another
+	// metasrv replica's independently-loaded view of the same cluster,
+	// writing directly to the shared storage.
+	clusterA := NewCluster(clusterPb, s, schedule.NewHeartbeatStreams(), "/test")
+	otherCluster := NewCluster(clusterPb, s, schedule.NewHeartbeatStreams(), "/test")
+
+	if _, err := otherCluster.GetOrCreateSchema(ctx, "schemaA"); err != nil {
+		t.Fatalf("GetOrCreateSchema: %v", err)
+	}
+	tables, errs := otherCluster.BatchGetOrCreateTables(ctx, []TableSpec{{SchemaName: "schemaA", TableName: "table0", NodeName: "node0"}})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("BatchGetOrCreateTables: %v", err)
+		}
+	}
+	if len(tables) != 1 {
+		t.Fatalf("got %d tables, want 1", len(tables))
+	}
+
+	provider := newInMemoryClusterProvider()
+	provider.AddCluster(clusterA)
+
+	opts := ManagerOptions{ReadPolicy: Cached}
+	m, err := NewManagerImplWithOptions(ctx, s, schedule.NewHeartbeatStreams(), "/test", provider, opts)
+	if err != nil {
+		t.Fatalf("NewManagerImplWithOptions: %v", err)
+	}
+	manager := m.(*managerImpl)
+
+	entry, err := manager.getClusterEntry(ctx, "clusterA")
+	if err != nil {
+		t.Fatalf("getClusterEntry: %v", err)
+	}
+
+	manager.reconcileClusterCache(ctx, entry)
+
+	got, err := manager.GetTables(ctx, "clusterA", "", nil)
+	if err != nil {
+		t.Fatalf("GetTables: %v", err)
+	}
+	found := false
+	for _, shardTables := range got {
+		for _, table := range shardTables.Tables {
+			if table.Name == "table0" {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Fatal("reconcileClusterCache did not observe table0, written directly to storage by another Cluster instance")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 CeresDB Project Authors. Licensed under Apache-2.0.
+
+// Package id hands out monotonically increasing IDs (cluster/schema/table
+// IDs) backed by a persisted watermark, so that restarts never hand out an
+// ID already in use.
+package id
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/CeresDB/ceresmeta/server/storage"
+	"github.com/pkg/errors"
+)
+
+// Allocator hands out IDs unique for its lifetime.
+type Allocator interface {
+	Alloc(ctx context.Context) (uint64, error)
+	// AllocN reserves a contiguous range of n IDs in a single storage round
+	// trip, returning the first ID in the range [first, first+n); it is the
+	// batch counterpart of Alloc, for callers creating many records at once
+	// that would otherwise pay one round trip per ID. n must be >= 1.
+	AllocN(ctx context.Context, n uint64) (uint64, error)
+	// Current returns the watermark's current value without advancing it,
+	// e.g. so a snapshot can record "every ID up to here is taken" without
+	// wasting one.
+	Current(ctx context.Context) (uint64, error)
+}
+
+// AllocatorImpl persists its watermark under rootPath/alloc/clusterName/prefix
+// via storage, so that a restart never reuses an ID, and so that distinct
+// clusters never share a watermark. The watermark is cached in memory after
+// the first load and written with an unconditional Put, so two allocators
+// writing the same key at the same time can hand out the same ID.
+type AllocatorImpl struct {
+	storage storage.Storage
+	key     string
+
+	mu      sync.Mutex
+	current uint64
+	loaded  bool
+}
+
+// NewAllocatorImpl returns an Allocator whose watermark is persisted at
+// rootPath/alloc/clusterName/prefix, e.g.
+// NewAllocatorImpl(s, "/horaedb", "clusterA", "TableID"); clusterName keeps
+// every cluster's watermark independent so that restoring or allocating for
+// one cluster never perturbs another's.
+func NewAllocatorImpl(s storage.Storage, rootPath, clusterName, prefix string) *AllocatorImpl {
+	return &AllocatorImpl{storage: s, key: path.Join(rootPath, "alloc", clusterName, prefix)}
+}
+
+// Alloc returns the next unused ID, persisting the new watermark before
+// returning so that a crash right after Alloc never hands the same ID out
+// twice.
+func (a *AllocatorImpl) Alloc(ctx context.Context) (uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.loaded {
+		current, err := a.loadLocked(ctx)
+		if err != nil {
+			return 0, errors.WithMessage(err, "AllocatorImpl load watermark")
+		}
+		a.current = current
+		a.loaded = true
+	}
+
+	next := a.current + 1
+	if err := a.storage.Put(ctx, a.key, strconv.FormatUint(next, 10)); err != nil {
+		return 0, errors.WithMessage(err, "AllocatorImpl persist watermark")
+	}
+	a.current = next
+	return a.current, nil
+}
+
+// AllocN reserves a contiguous range of n IDs, persisting the new watermark
+// in a single storage.Put rather than one Put per ID, and returns the first
+// ID of the newly-reserved range.
+func (a *AllocatorImpl) AllocN(ctx context.Context, n uint64) (uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.loaded {
+		current, err := a.loadLocked(ctx)
+		if err != nil {
+			return 0, errors.WithMessage(err, "AllocatorImpl load watermark")
+		}
+		a.current = current
+		a.loaded = true
+	}
+
+	first := a.current + 1
+	next := a.current + n
+	if err := a.storage.Put(ctx, a.key, strconv.FormatUint(next, 10)); err != nil {
+		return 0, errors.WithMessage(err, "AllocatorImpl persist watermark")
+	}
+	a.current = next
+	return first, nil
+}
+
+// Current returns the watermark's current value without advancing it.
+func (a *AllocatorImpl) Current(ctx context.Context) (uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.loaded {
+		current, err := a.loadLocked(ctx)
+		if err != nil {
+			return 0, errors.WithMessage(err, "AllocatorImpl load watermark")
+		}
+		a.current = current
+		a.loaded = true
+	}
+	return a.current, nil
+}
+
+func (a *AllocatorImpl) loadLocked(ctx context.Context) (uint64, error) {
+	value, err := a.storage.Get(ctx, a.key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "AllocatorImpl parse watermark %q", value)
+	}
+	return current, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 CeresDB Project Authors. Licensed under Apache-2.0.
+
+// Package schedule fans out shard-assignment events to the HoraeDB nodes
+// registered with a cluster.
+package schedule
+
+import "sync"
+
+// HeartbeatStreams multiplexes shard-assignment events onto the live
+// heartbeat stream of each registered node, so that RegisterNode/DropTable
+// etc. can push updates without each node polling for them.
+type HeartbeatStreams struct {
+	mu      sync.Mutex
+	streams map[string]chan interface{}
+}
+
+// NewHeartbeatStreams creates an empty stream registry.
+func NewHeartbeatStreams() *HeartbeatStreams {
+	return &HeartbeatStreams{streams: make(map[string]chan interface{})}
+}
+
+// BindNode registers the channel a node's heartbeat loop reads shard
+// assignment events from.
+func (s *HeartbeatStreams) BindNode(nodeName string, stream chan interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[nodeName] = stream
+}
+
+// UnbindNode removes a node's stream, e.g. once its lease expires.
+func (s *HeartbeatStreams) UnbindNode(nodeName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, nodeName)
+}
+
+// Send pushes event to nodeName's stream if it is currently bound; it is a
+// no-op if the node has no live stream.
+func (s *HeartbeatStreams) Send(nodeName string, event interface{}) {
+	s.mu.Lock()
+	stream, ok := s.streams[nodeName]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case stream <- event:
+	default:
+	}
+}
+
+// Broadcast pushes event to every currently bound node.
+func (s *HeartbeatStreams) Broadcast(event interface{}) {
+	s.mu.Lock()
+	nodeNames := make([]string, 0, len(s.streams))
+	for nodeName := range s.streams {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	s.mu.Unlock()
+
+	for _, nodeName := range nodeNames {
+		s.Send(nodeName, event)
+	}
+}
@@ -0,0 +1,652 @@
+// Copyright 2022 CeresDB Project Authors. Licensed under Apache-2.0.
+
+// Package storage persists cluster metadata (cluster/schema/table/shard/node
+// records and simple allocator counters) to etcd.
+package storage
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CeresDB/ceresdbproto/pkg/clusterpb"
+	"github.com/golang/protobuf/proto" //nolint:staticcheck // clusterpb is generated against the legacy proto-gen-go API
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ErrNotFound is returned by Get/GetCluster when the requested key has no
+// persisted value.
+var ErrNotFound = errors.New("key not found")
+
+const clustersDir = "clusters"
+
+// ClusterEvent is an out-of-band cluster creation/deletion observed via
+// WatchClusters, e.g. performed by another metasrv replica or an operator
+// tool.
+type ClusterEvent struct {
+	ClusterName string
+	Deleted     bool
+}
+
+// Storage is CeresMeta's persistence layer for cluster metadata. The
+// production implementation is etcd-backed (NewEtcdStorage); tests may
+// substitute NewMemoryStorage instead.
+type Storage interface {
+	ListClusters(ctx context.Context) ([]*clusterpb.Cluster, error)
+	GetCluster(ctx context.Context, clusterName string) (*clusterpb.Cluster, error)
+	CreateCluster(ctx context.Context, cluster *clusterpb.Cluster) (*clusterpb.Cluster, error)
+
+	// CASClusterUUID atomically assigns uuid to clusterName's persisted
+	// record iff it doesn't already have one, reporting whether this call
+	// won the race. Used to upgrade clusters created before ClusterUUID
+	// existed without double-assigning under concurrent leader elections.
+	CASClusterUUID(ctx context.Context, clusterName, uuid string) (bool, error)
+
+	// RestoreCluster transactionally persists a full cluster snapshot
+	// (schemas/tables/shards/nodes) and bumps the schema/table ID
+	// allocator watermarks past the max ID in the snapshot, so that
+	// freshly allocated IDs never collide with restored ones. It fails if
+	// a cluster with the same name already exists.
+	RestoreCluster(ctx context.Context, cluster *clusterpb.Cluster, schemas []*clusterpb.Schema,
+		tables []*clusterpb.Table, shards []*clusterpb.Shard, nodes []*clusterpb.Node,
+		schemaIDWatermark, tableIDWatermark uint64,
+	) (*clusterpb.Cluster, error)
+
+	// WatchClusters streams cluster creation/deletion until ctx is
+	// cancelled, letting callers react to changes made by another metasrv
+	// replica without a full re-list.
+	WatchClusters(ctx context.Context) (<-chan ClusterEvent, error)
+
+	// Get/Put/Delete/CAS/List back plain string key-value pairs; id.Allocator
+	// uses Get/Put to persist its watermark, and the cluster package uses
+	// Put/List (plus BatchPut/BatchDelete below) to persist its
+	// schema/table/shard/node records without needing a bespoke Storage
+	// method per record type.
+	Get(ctx context.Context, key string) (string, error)
+	Put(ctx context.Context, key, value string) error
+	Delete(ctx context.Context, key string) error
+	// CAS stores value at key iff its current value equals expected (""
+	// meaning "key must not exist yet"), reporting whether this call won
+	// the race.
+	CAS(ctx context.Context, key, expected, value string) (bool, error)
+	// List returns every key-value pair whose key has the given prefix, so
+	// that e.g. Cluster.Load can enumerate "<rootPath>/<cluster>/tables/"
+	// without knowing the table names in advance.
+	List(ctx context.Context, prefix string) (map[string]string, error)
+	// BatchPut writes every key-value pair in kvs as a single atomic
+	// operation, so callers persisting many related records at once (e.g.
+	// a batch of new tables plus the shards they land on) pay one round
+	// trip instead of one per record.
+	BatchPut(ctx context.Context, kvs map[string]string) error
+	// BatchDelete removes every key in keys as a single atomic operation.
+	BatchDelete(ctx context.Context, keys []string) error
+}
+
+// etcdStorage is the production Storage, backed directly by etcd.
+type etcdStorage struct {
+	client   *clientv3.Client
+	rootPath string
+}
+
+// NewEtcdStorage returns an etcd-backed Storage rooted at rootPath.
+func NewEtcdStorage(client *clientv3.Client, rootPath string) Storage {
+	return &etcdStorage{client: client, rootPath: rootPath}
+}
+
+func (s *etcdStorage) clusterKey(clusterName string) string {
+	return path.Join(s.rootPath, clustersDir, clusterName)
+}
+
+func (s *etcdStorage) allocKey(clusterName, prefix string) string {
+	// clusterName/prefix must match the key id.NewAllocatorImpl builds for
+	// the cluster package's id.Allocator, so that a watermark restored here
+	// is actually the one that Allocator reads from afterwards.
+	return path.Join(s.rootPath, "alloc", clusterName, prefix)
+}
+
+// restoredWatermark returns max(current, restored): RestoreCluster must never
+// lower a watermark below what's already persisted, or a subsequent Alloc
+// could hand out an ID that was already used before the restore.
+func (s *etcdStorage) restoredWatermark(ctx context.Context, key string, restored uint64) (uint64, error) {
+	current, err := s.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return restored, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "etcdStorage restoredWatermark get")
+	}
+	currentWatermark, err := strconv.ParseUint(current, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "etcdStorage restoredWatermark parse %q", current)
+	}
+	if currentWatermark > restored {
+		return currentWatermark, nil
+	}
+	return restored, nil
+}
+
+func (s *etcdStorage) ListClusters(ctx context.Context) ([]*clusterpb.Cluster, error) {
+	resp, err := s.client.Get(ctx, path.Join(s.rootPath, clustersDir)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdStorage ListClusters")
+	}
+
+	clusters := make([]*clusterpb.Cluster, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		clusterPb := &clusterpb.Cluster{}
+		if err := proto.Unmarshal(kv.Value, clusterPb); err != nil {
+			return nil, errors.Wrap(err, "etcdStorage ListClusters decode")
+		}
+		clusters = append(clusters, clusterPb)
+	}
+	return clusters, nil
+}
+
+func (s *etcdStorage) GetCluster(ctx context.Context, clusterName string) (*clusterpb.Cluster, error) {
+	resp, err := s.client.Get(ctx, s.clusterKey(clusterName))
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdStorage GetCluster")
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrNotFound
+	}
+
+	clusterPb := &clusterpb.Cluster{}
+	if err := proto.Unmarshal(resp.Kvs[0].Value, clusterPb); err != nil {
+		return nil, errors.Wrap(err, "etcdStorage GetCluster decode")
+	}
+	return clusterPb, nil
+}
+
+func (s *etcdStorage) CreateCluster(ctx context.Context, cluster *clusterpb.Cluster) (*clusterpb.Cluster, error) {
+	value, err := proto.Marshal(cluster)
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdStorage CreateCluster marshal")
+	}
+
+	key := s.clusterKey(cluster.GetName())
+	txnResp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdStorage CreateCluster")
+	}
+	if !txnResp.Succeeded {
+		return nil, errors.Errorf("cluster already exists, clusterName:%s", cluster.GetName())
+	}
+	return cluster, nil
+}
+
+func (s *etcdStorage) CASClusterUUID(ctx context.Context, clusterName, uuid string) (bool, error) {
+	key := s.clusterKey(clusterName)
+	for {
+		resp, err := s.client.Get(ctx, key)
+		if err != nil {
+			return false, errors.Wrap(err, "etcdStorage CASClusterUUID get")
+		}
+		if len(resp.Kvs) == 0 {
+			return false, ErrNotFound
+		}
+
+		current := &clusterpb.Cluster{}
+		if err := proto.Unmarshal(resp.Kvs[0].Value, current); err != nil {
+			return false, errors.Wrap(err, "etcdStorage CASClusterUUID decode")
+		}
+		if current.GetUuid() != "" {
+			return false, nil
+		}
+		current.Uuid = uuid
+
+		value, err := proto.Marshal(current)
+		if err != nil {
+			return false, errors.Wrap(err, "etcdStorage CASClusterUUID marshal")
+		}
+
+		txnResp, err := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)).
+			Then(clientv3.OpPut(key, string(value))).
+			Commit()
+		if err != nil {
+			return false, errors.Wrap(err, "etcdStorage CASClusterUUID")
+		}
+		if txnResp.Succeeded {
+			return true, nil
+		}
+		// Lost the race against a concurrent writer (e.g. another leader
+		// election assigning a UUID at the same time); retry against the
+		// now-current revision.
+	}
+}
+
+func (s *etcdStorage) RestoreCluster(ctx context.Context, cluster *clusterpb.Cluster, schemas []*clusterpb.Schema,
+	tables []*clusterpb.Table, shards []*clusterpb.Shard, nodes []*clusterpb.Node,
+	schemaIDWatermark, tableIDWatermark uint64,
+) (*clusterpb.Cluster, error) {
+	clusterValue, err := proto.Marshal(cluster)
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdStorage RestoreCluster marshal cluster")
+	}
+
+	ops := make([]clientv3.Op, 0, 3+len(schemas)+len(tables)+len(shards)+len(nodes))
+	ops = append(ops, clientv3.OpPut(s.clusterKey(cluster.GetName()), string(clusterValue)))
+
+	for _, schema := range schemas {
+		value, err := proto.Marshal(schema)
+		if err != nil {
+			return nil, errors.Wrap(err, "etcdStorage RestoreCluster marshal schema")
+		}
+		ops = append(ops, clientv3.OpPut(path.Join(s.rootPath, cluster.GetName(), "schemas", schema.GetName()), string(value)))
+	}
+	for _, table := range tables {
+		value, err := proto.Marshal(table)
+		if err != nil {
+			return nil, errors.Wrap(err, "etcdStorage RestoreCluster marshal table")
+		}
+		ops = append(ops, clientv3.OpPut(path.Join(s.rootPath, cluster.GetName(), "tables", strconv.FormatUint(table.GetId(), 10)), string(value)))
+	}
+	for _, shard := range shards {
+		value, err := proto.Marshal(shard)
+		if err != nil {
+			return nil, errors.Wrap(err, "etcdStorage RestoreCluster marshal shard")
+		}
+		ops = append(ops, clientv3.OpPut(path.Join(s.rootPath, cluster.GetName(), "shards", strconv.FormatUint(uint64(shard.GetId()), 10)), string(value)))
+	}
+	for _, node := range nodes {
+		value, err := proto.Marshal(node)
+		if err != nil {
+			return nil, errors.Wrap(err, "etcdStorage RestoreCluster marshal node")
+		}
+		ops = append(ops, clientv3.OpPut(path.Join(s.rootPath, cluster.GetName(), "nodes", node.GetName()), string(value)))
+	}
+
+	schemaIDKey := s.allocKey(cluster.GetName(), AllocSchemaIDPrefix)
+	tableIDKey := s.allocKey(cluster.GetName(), AllocTableIDPrefix)
+	restoredSchemaIDWatermark, err := s.restoredWatermark(ctx, schemaIDKey, schemaIDWatermark)
+	if err != nil {
+		return nil, errors.WithMessage(err, "etcdStorage RestoreCluster schemaID watermark")
+	}
+	restoredTableIDWatermark, err := s.restoredWatermark(ctx, tableIDKey, tableIDWatermark)
+	if err != nil {
+		return nil, errors.WithMessage(err, "etcdStorage RestoreCluster tableID watermark")
+	}
+
+	ops = append(ops,
+		clientv3.OpPut(schemaIDKey, strconv.FormatUint(restoredSchemaIDWatermark, 10)),
+		clientv3.OpPut(tableIDKey, strconv.FormatUint(restoredTableIDWatermark, 10)),
+	)
+
+	key := s.clusterKey(cluster.GetName())
+	txnResp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdStorage RestoreCluster")
+	}
+	if !txnResp.Succeeded {
+		return nil, errors.Errorf("cluster already exists, clusterName:%s", cluster.GetName())
+	}
+	return cluster, nil
+}
+
+func (s *etcdStorage) WatchClusters(ctx context.Context) (<-chan ClusterEvent, error) {
+	prefix := path.Join(s.rootPath, clustersDir) + "/"
+	watchCh := s.client.Watch(ctx, prefix, clientv3.WithPrefix())
+
+	events := make(chan ClusterEvent)
+	go func() {
+		defer close(events)
+		for resp := range watchCh {
+			for _, ev := range resp.Events {
+				event := ClusterEvent{ClusterName: path.Base(string(ev.Kv.Key)), Deleted: ev.Type == clientv3.EventTypeDelete}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Get/Put/CAS/List treat key as already fully qualified (callers, e.g.
+// id.Allocator and the cluster package, build their own keys off the same
+// rootPath this Storage was constructed with) rather than prefixing it with
+// s.rootPath again.
+
+func (s *etcdStorage) Get(ctx context.Context, key string) (string, error) {
+	resp, err := s.client.Get(ctx, key)
+	if err != nil {
+		return "", errors.Wrap(err, "etcdStorage Get")
+	}
+	if len(resp.Kvs) == 0 {
+		return "", ErrNotFound
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (s *etcdStorage) Put(ctx context.Context, key, value string) error {
+	_, err := s.client.Put(ctx, key, value)
+	return errors.Wrap(err, "etcdStorage Put")
+}
+
+func (s *etcdStorage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.Delete(ctx, key)
+	return errors.Wrap(err, "etcdStorage Delete")
+}
+
+func (s *etcdStorage) CAS(ctx context.Context, key, expected, value string) (bool, error) {
+	var cmp clientv3.Cmp
+	if expected == "" {
+		cmp = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	} else {
+		cmp = clientv3.Compare(clientv3.Value(key), "=", expected)
+	}
+
+	txnResp, err := s.client.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, value)).Commit()
+	if err != nil {
+		return false, errors.Wrap(err, "etcdStorage CAS")
+	}
+	return txnResp.Succeeded, nil
+}
+
+func (s *etcdStorage) BatchPut(ctx context.Context, kvs map[string]string) error {
+	ops := make([]clientv3.Op, 0, len(kvs))
+	for key, value := range kvs {
+		ops = append(ops, clientv3.OpPut(key, value))
+	}
+	_, err := s.client.Txn(ctx).Then(ops...).Commit()
+	return errors.Wrap(err, "etcdStorage BatchPut")
+}
+
+func (s *etcdStorage) BatchDelete(ctx context.Context, keys []string) error {
+	ops := make([]clientv3.Op, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, clientv3.OpDelete(key))
+	}
+	_, err := s.client.Txn(ctx).Then(ops...).Commit()
+	return errors.Wrap(err, "etcdStorage BatchDelete")
+}
+
+func (s *etcdStorage) List(ctx context.Context, prefix string) (map[string]string, error) {
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrap(err, "etcdStorage List")
+	}
+
+	result := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		result[string(kv.Key)] = string(kv.Value)
+	}
+	return result, nil
+}
+
+// memoryStorage is an in-process Storage, for tests that want to exercise
+// the cluster package without etcd.
+type memoryStorage struct {
+	mu       sync.Mutex
+	rootPath string
+	clusters map[string]*clusterpb.Cluster
+	kv       map[string]string
+	watchers []chan ClusterEvent
+}
+
+// NewMemoryStorage returns a Storage backed by a plain map, rooted at
+// rootPath like NewEtcdStorage.
+func NewMemoryStorage(rootPath string) Storage {
+	return &memoryStorage{rootPath: rootPath, clusters: make(map[string]*clusterpb.Cluster), kv: make(map[string]string)}
+}
+
+func (s *memoryStorage) ListClusters(_ context.Context) ([]*clusterpb.Cluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.clusters))
+	for name := range s.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clusters := make([]*clusterpb.Cluster, 0, len(names))
+	for _, name := range names {
+		clusters = append(clusters, proto.Clone(s.clusters[name]).(*clusterpb.Cluster))
+	}
+	return clusters, nil
+}
+
+// GetCluster returns an independent copy of the persisted record, just as
+// etcdStorage's would be freshly unmarshaled from etcd on every call, so
+// that two callers loading the same cluster never end up mutating each
+// other's in-memory state through a shared pointer.
+func (s *memoryStorage) GetCluster(_ context.Context, clusterName string) (*clusterpb.Cluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clusterPb, ok := s.clusters[clusterName]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.Clone(clusterPb).(*clusterpb.Cluster), nil
+}
+
+func (s *memoryStorage) CreateCluster(_ context.Context, cluster *clusterpb.Cluster) (*clusterpb.Cluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clusters[cluster.GetName()]; ok {
+		return nil, errors.Errorf("cluster already exists, clusterName:%s", cluster.GetName())
+	}
+	s.clusters[cluster.GetName()] = cluster
+	s.notifyLocked(ClusterEvent{ClusterName: cluster.GetName()})
+	return cluster, nil
+}
+
+func (s *memoryStorage) CASClusterUUID(_ context.Context, clusterName, uuid string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clusterPb, ok := s.clusters[clusterName]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if clusterPb.GetUuid() != "" {
+		return false, nil
+	}
+	clusterPb.Uuid = uuid
+	return true, nil
+}
+
+func (s *memoryStorage) RestoreCluster(_ context.Context, cluster *clusterpb.Cluster, schemas []*clusterpb.Schema,
+	tables []*clusterpb.Table, shards []*clusterpb.Shard, nodes []*clusterpb.Node,
+	schemaIDWatermark, tableIDWatermark uint64,
+) (*clusterpb.Cluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clusters[cluster.GetName()]; ok {
+		return nil, errors.Errorf("cluster already exists, clusterName:%s", cluster.GetName())
+	}
+
+	// Every record is marshaled into kvs before anything is written, so a
+	// marshal failure leaves storage untouched.
+	kvs := make(map[string]string, len(schemas)+len(tables)+len(shards)+len(nodes))
+	for _, schema := range schemas {
+		value, err := proto.Marshal(schema)
+		if err != nil {
+			return nil, errors.Wrap(err, "memoryStorage RestoreCluster marshal schema")
+		}
+		kvs[path.Join(s.rootPath, cluster.GetName(), "schemas", schema.GetName())] = string(value)
+	}
+	for _, table := range tables {
+		value, err := proto.Marshal(table)
+		if err != nil {
+			return nil, errors.Wrap(err, "memoryStorage RestoreCluster marshal table")
+		}
+		kvs[path.Join(s.rootPath, cluster.GetName(), "tables", strconv.FormatUint(table.GetId(), 10))] = string(value)
+	}
+	for _, shard := range shards {
+		value, err := proto.Marshal(shard)
+		if err != nil {
+			return nil, errors.Wrap(err, "memoryStorage RestoreCluster marshal shard")
+		}
+		kvs[path.Join(s.rootPath, cluster.GetName(), "shards", strconv.FormatUint(uint64(shard.GetId()), 10))] = string(value)
+	}
+	for _, node := range nodes {
+		value, err := proto.Marshal(node)
+		if err != nil {
+			return nil, errors.Wrap(err, "memoryStorage RestoreCluster marshal node")
+		}
+		kvs[path.Join(s.rootPath, cluster.GetName(), "nodes", node.GetName())] = string(value)
+	}
+
+	s.clusters[cluster.GetName()] = cluster
+	for key, value := range kvs {
+		s.kv[key] = value
+	}
+
+	schemaIDKey := path.Join(s.rootPath, "alloc", cluster.GetName(), AllocSchemaIDPrefix)
+	tableIDKey := path.Join(s.rootPath, "alloc", cluster.GetName(), AllocTableIDPrefix)
+	s.kv[schemaIDKey] = strconv.FormatUint(s.restoredWatermarkLocked(schemaIDKey, schemaIDWatermark), 10)
+	s.kv[tableIDKey] = strconv.FormatUint(s.restoredWatermarkLocked(tableIDKey, tableIDWatermark), 10)
+	s.notifyLocked(ClusterEvent{ClusterName: cluster.GetName()})
+	return cluster, nil
+}
+
+// restoredWatermarkLocked returns max(current, restored); s.mu must already be
+// held. RestoreCluster must never lower a watermark below what's already
+// persisted, or a subsequent Alloc could hand out an ID reused from before
+// the restore.
+func (s *memoryStorage) restoredWatermarkLocked(key string, restored uint64) uint64 {
+	current, ok := s.kv[key]
+	if !ok {
+		return restored
+	}
+	currentWatermark, err := strconv.ParseUint(current, 10, 64)
+	if err != nil || currentWatermark < restored {
+		return restored
+	}
+	return currentWatermark
+}
+
+func (s *memoryStorage) WatchClusters(ctx context.Context) (<-chan ClusterEvent, error) {
+	s.mu.Lock()
+	ch := make(chan ClusterEvent, 16)
+	s.watchers = append(s.watchers, ch)
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, watcher := range s.watchers {
+			if watcher == ch {
+				s.watchers = append(s.watchers[:i], s.watchers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// notifyLocked must be called with s.mu held.
+func (s *memoryStorage) notifyLocked(event ClusterEvent) {
+	for _, watcher := range s.watchers {
+		select {
+		case watcher <- event:
+		default:
+		}
+	}
+}
+
+func (s *memoryStorage) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.kv[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *memoryStorage) Put(_ context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.kv[key] = value
+	return nil
+}
+
+func (s *memoryStorage) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.kv, key)
+	return nil
+}
+
+func (s *memoryStorage) CAS(_ context.Context, key, expected, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.kv[key]
+	if expected == "" {
+		if ok {
+			return false, nil
+		}
+	} else if current != expected {
+		return false, nil
+	}
+	s.kv[key] = value
+	return true, nil
+}
+
+func (s *memoryStorage) BatchPut(_ context.Context, kvs map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, value := range kvs {
+		s.kv[key] = value
+	}
+	return nil
+}
+
+func (s *memoryStorage) BatchDelete(_ context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.kv, key)
+	}
+	return nil
+}
+
+func (s *memoryStorage) List(_ context.Context, prefix string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	withSlash := prefix + "/"
+	result := make(map[string]string)
+	for key, value := range s.kv {
+		if strings.HasPrefix(key, withSlash) {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// AllocClusterIDPrefix/AllocSchemaIDPrefix/AllocTableIDPrefix mirror the
+// identically-named constants in server/cluster, which construct their
+// id.Allocator instances against these same key prefixes.
+const (
+	AllocClusterIDPrefix = "ClusterID"
+	AllocSchemaIDPrefix  = "SchemaID"
+	AllocTableIDPrefix   = "TableID"
+)
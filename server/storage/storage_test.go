@@ -0,0 +1,120 @@
+// Copyright 2022 CeresDB Project Authors. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/ceresdbproto/pkg/clusterpb"
+)
+
+// TestRestoreClusterNeverLowersWatermark asserts RestoreCluster persists
+// max(current, restored) for each ID watermark instead of overwriting it
+// unconditionally, so that restoring an older snapshot over a cluster that
+// has since allocated further IDs can never cause those IDs to be reused.
+func TestRestoreClusterNeverLowersWatermark(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage("/test")
+
+	if err := s.Put(ctx, "/test/alloc/clusterA/SchemaID", "100"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Put(ctx, "/test/alloc/clusterA/TableID", "100"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Restoring a snapshot whose watermarks are lower than what's already
+	// persisted (e.g. an older backup) must not roll the watermark back.
+	if _, err := s.RestoreCluster(ctx, &clusterpb.Cluster{Name: "clusterA"}, nil, nil, nil, nil, 10, 10); err != nil {
+		t.Fatalf("RestoreCluster: %v", err)
+	}
+
+	schemaID, err := s.Get(ctx, "/test/alloc/clusterA/SchemaID")
+	if err != nil {
+		t.Fatalf("Get SchemaID: %v", err)
+	}
+	if schemaID != "100" {
+		t.Fatalf("SchemaID watermark = %q, want unchanged \"100\": restore must never lower a watermark", schemaID)
+	}
+
+	tableID, err := s.Get(ctx, "/test/alloc/clusterA/TableID")
+	if err != nil {
+		t.Fatalf("Get TableID: %v", err)
+	}
+	if tableID != "100" {
+		t.Fatalf("TableID watermark = %q, want unchanged \"100\": restore must never lower a watermark", tableID)
+	}
+}
+
+// TestRestoreClusterWatermarksAreScopedPerCluster asserts that restoring one
+// cluster's watermarks never perturbs another cluster's, since both
+// etcdStorage and memoryStorage key the allocator watermark off
+// rootPath/alloc/clusterName/prefix rather than rootPath/alloc/prefix.
+func TestRestoreClusterWatermarksAreScopedPerCluster(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage("/test")
+
+	if err := s.Put(ctx, "/test/alloc/clusterB/TableID", "500"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if _, err := s.RestoreCluster(ctx, &clusterpb.Cluster{Name: "clusterA"}, nil, nil, nil, nil, 1, 1); err != nil {
+		t.Fatalf("RestoreCluster: %v", err)
+	}
+
+	clusterBTableID, err := s.Get(ctx, "/test/alloc/clusterB/TableID")
+	if err != nil {
+		t.Fatalf("Get clusterB TableID: %v", err)
+	}
+	if clusterBTableID != "500" {
+		t.Fatalf("clusterB TableID watermark = %q, want unchanged \"500\": restoring clusterA must not touch clusterB's watermark", clusterBTableID)
+	}
+
+	clusterATableID, err := s.Get(ctx, "/test/alloc/clusterA/TableID")
+	if err != nil {
+		t.Fatalf("Get clusterA TableID: %v", err)
+	}
+	if clusterATableID != "1" {
+		t.Fatalf("clusterA TableID watermark = %q, want \"1\"", clusterATableID)
+	}
+}
+
+// TestWatchClustersObservesCreateAndRespectsCtxCancellation exercises
+// memoryStorage.WatchClusters: it must observe a cluster created after
+// Watch is called, and must close its event channel once ctx is cancelled
+// rather than leaking the watcher forever.
+func TestWatchClustersObservesCreateAndRespectsCtxCancellation(t *testing.T) {
+	s := NewMemoryStorage("/test")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events, err := s.WatchClusters(ctx)
+	if err != nil {
+		t.Fatalf("WatchClusters: %v", err)
+	}
+
+	if _, err := s.CreateCluster(ctx, &clusterpb.Cluster{Name: "clusterA"}); err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.ClusterName != "clusterA" || event.Deleted {
+			t.Fatalf("got event %+v, want {ClusterName: clusterA, Deleted: false}", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WatchClusters did not observe CreateCluster")
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("expected events channel to be closed after ctx cancellation")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("events channel was not closed after ctx cancellation")
+	}
+}